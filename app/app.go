@@ -0,0 +1,106 @@
+package app
+
+import (
+	"DistributedGo/log"
+	"DistributedGo/services"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// app 包把注册中心、日志服务和成绩服务的启动逻辑做成可以复用的函数, cmd下的命令和根目录的main.go都使用它们.
+// 每个RunXxx函数在ctx结束时关闭对应的组件, 返回的ctx在组件关闭完成后结束,
+// 调用方可以据此按依赖关系的逆序逐个关闭: 成绩服务 -> 日志服务 -> 注册中心.
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// ServiceOptions 启动服务的配置
+type ServiceOptions struct {
+	Host          string                // 为空时使用localhost
+	Port          string                // 为空时使用服务的默认端口, 例如":10001"; ":0"表示由系统分配
+	AdvertiseAddr string                // 注册到注册中心的地址, 为空时使用Host和实际监听的端口
+	Instance      string                // 实例后缀, 同一个服务启动多个实例时用来区分
+	TLS           *services.TLSConfig   // 为nil时使用HTTP
+	Admin         *services.AdminConfig // 为nil时不启用管理接口
+}
+
+func (o ServiceOptions) addr(defaultPort string) (string, string) {
+	host, port := o.Host, o.Port
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	return host, port
+}
+
+// TLSFlags 在默认的FlagSet上注册TLS相关的参数, flag.Parse之后调用返回的函数得到TLS配置, 没有启用TLS时为nil.
+// 使用自签名证书或者指定-tls-insecure时, 进程中的客户端不校验服务端证书.
+func TLSFlags() func() *services.TLSConfig {
+	cert := flag.String("tls-cert", "", "HTTPS使用的证书文件")
+	key := flag.String("tls-key", "", "HTTPS使用的私钥文件")
+	selfSigned := flag.Bool("tls-self-signed", false, "使用启动时生成的自签名证书提供HTTPS, 只在开发中使用")
+	insecure := flag.Bool("tls-insecure", false, "访问其他服务时不校验证书, 其他服务使用自签名证书时需要")
+	return func() *services.TLSConfig {
+		if *selfSigned || *insecure {
+			services.InsecureSkipVerify()
+		}
+		if *cert == "" && *key == "" && !*selfSigned {
+			return nil
+		}
+		return &services.TLSConfig{CertFile: *cert, KeyFile: *key, SelfSigned: *selfSigned}
+	}
+}
+
+// adminTokenEnv 管理接口token的环境变量, 命令行参数会出现在进程列表中
+const adminTokenEnv = "DISTRIBUTEDGO_ADMIN_TOKEN"
+
+// AdminFlags 在默认的FlagSet上注册管理接口的参数, flag.Parse之后调用返回的函数得到配置, 没有启用时为nil.
+func AdminFlags() func() *services.AdminConfig {
+	addr := flag.String("admin-addr", "", "管理接口(pprof等)单独的监听地址, 例如localhost:6060")
+	token := flag.String("admin-token", os.Getenv(adminTokenEnv), "管理接口的token, 不使用单独的地址时挂在服务的端口上. 默认读取环境变量"+adminTokenEnv)
+	return func() *services.AdminConfig {
+		if *addr == "" && *token == "" {
+			return nil
+		}
+		return &services.AdminConfig{Addr: *addr, Token: *token}
+	}
+}
+
+// LogRotateFlags 在默认的FlagSet上注册日志轮转的参数, 默认值为log.DefaultRotateConfig. flag.Parse之后调用返回的函数得到配置.
+func LogRotateFlags() func() log.RotateConfig {
+	d := log.DefaultRotateConfig
+	maxSize := flag.Int64("log-max-size", d.MaxSize>>20, "日志文件超过多少MB时轮转, 0表示不按大小轮转")
+	interval := flag.Duration("log-rotate-interval", d.Interval, "按时间周期轮转日志文件, 0表示不按时间轮转")
+	compress := flag.Bool("log-compress", d.Compress, "用gzip压缩轮转后的日志文件")
+	maxBackups := flag.Int("log-max-backups", d.MaxBackups, "最多保留的轮转文件数量, 0表示不限制")
+	maxAge := flag.Duration("log-max-age", d.MaxAge, "轮转文件的最长保留时间, 0表示不限制")
+	return func() log.RotateConfig {
+		return log.RotateConfig{
+			MaxSize:    *maxSize << 20,
+			Interval:   *interval,
+			Compress:   *compress,
+			MaxBackups: *maxBackups,
+			MaxAge:     *maxAge,
+		}
+	}
+}
+
+// ShutdownContext 返回收到SIGINT/SIGTERM时结束的ctx. stdin为true时, 在标准输入中输入任意内容也会结束.
+func ShutdownContext(stdin bool) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if stdin {
+		go func() {
+			fmt.Println("按任意键退出...")
+			var s string
+			_, _ = fmt.Scan(&s)
+			stop()
+		}()
+	}
+	return ctx, stop
+}
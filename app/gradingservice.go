@@ -0,0 +1,83 @@
+package app
+
+import (
+	"DistributedGo/grades"
+	"DistributedGo/log"
+	"DistributedGo/registry"
+	"DistributedGo/services"
+	"context"
+	"fmt"
+	stlog "log"
+	"os"
+	"time"
+)
+
+// RunGradingService 启动成绩服务. 成绩服务使用自己的Logger, 日志服务可用时发往日志服务, 不可用时本地输出.
+// 进程全局的标准库log保持不变, 同一个进程中的注册中心和日志服务自己的日志不会发往日志服务.
+func RunGradingService(ctx context.Context, opts ServiceOptions) (context.Context, error) {
+	host, port := opts.addr(":10002")
+	// 注册使用的URL由services.Run根据实际监听的地址生成
+	re := registry.RegistrationEntry{
+		ServiceName:      registry.GradingService,
+		RequiredServices: []registry.ServiceName{registry.LogService},
+		LoadBalancing:    map[registry.ServiceName]registry.BalancerType{registry.LogService: registry.RoundRobin},
+		ServiceUpdateURL: "/services",
+		HeartbeatURL:     "/health",
+	}
+	// 服务使用自己的客户端, 依赖的日志服务也通过它发现
+	client := registry.NewClient(registry.ClientOptions{})
+	logger := stlog.New(os.Stderr, "", stlog.LstdFlags)
+	grades.SetLogger(logger)
+	// 日志服务不可用时改为本地输出日志, 成绩服务仍然可以处理请求, 所以只是warning
+	health := services.NewHealth()
+	health.AddReadiness("students", grades.CheckStudents)
+	health.AddWarning("providers", services.ProvidersPresent(client, re.RequiredServices...))
+	done, err := services.Run(ctx, services.Config{
+		Host:             host,
+		Port:             port,
+		AdvertiseAddr:    opts.AdvertiseAddr,
+		Instance:         opts.Instance,
+		TLS:              opts.TLS,
+		Admin:            opts.Admin,
+		Registration:     re,
+		RegisterHandlers: grades.RegisterHandler,
+		IgnoreSignals:    true,
+		Tracing:          true,
+		Client:           client,
+		Health:           health,
+		RouteTimeouts:    map[string]time.Duration{"/students": 5 * time.Second},
+	})
+	if err != nil {
+		return done, err
+	}
+
+	// 日志服务的实例是注册成功后由注册中心异步推送的, 先等待一段时间. 等待期间收到关闭信号时立即停止等待
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	if logProvider, err := client.WaitForProvider(waitCtx, registry.LogService); err == nil {
+		fmt.Println("Log service provider found: ", logProvider)
+	} else {
+		fmt.Println("Log service provider not found, logging locally: ", err)
+	}
+	cancel()
+	// 日志服务上线时把日志发往日志服务, 全部下线时改回本地输出, 避免日志丢失
+	stopWatching := client.OnProviderChange(registry.LogService, func(e registry.ProviderEvent) {
+		if _, err := client.GetProvider(registry.LogService); err == nil {
+			// 通过服务名访问日志服务, 每次发送日志时由registry.Transport选择实例
+			log.RedirectLogger(logger, client, fmt.Sprintf("http://%s/log", registry.LogService), re.ServiceName)
+			logger.Printf("Log service instance %s: %s\n", e.Type, e.Instance.URL)
+		} else {
+			// RedirectLogger去掉了时间戳, 本地输出时和输出目标一起恢复
+			logger.SetOutput(os.Stderr)
+			logger.SetFlags(stlog.LstdFlags)
+			logger.Printf("Log service instance %s: %s, logging locally\n", e.Type, e.Instance.URL)
+		}
+	})
+	go func() {
+		<-done.Done()
+		// 服务关闭后客户端不再接收日志服务的变化, 改回本地输出
+		stopWatching()
+		logger.SetOutput(os.Stderr)
+		logger.SetFlags(stlog.LstdFlags)
+	}()
+	return done, nil
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"DistributedGo/log"
+	"DistributedGo/registry"
+	"DistributedGo/services"
+	"context"
+	stlog "log"
+)
+
+// RunLogService 启动日志服务, 日志写入destination文件, 按rotate轮转. ctx结束时先注销再关闭.
+func RunLogService(ctx context.Context, destination string, rotate log.RotateConfig, opts ServiceOptions) (context.Context, error) {
+	log.RunWithRotation(destination, rotate) // 初始化日志服务, 指定日志文件路径
+	host, port := opts.addr(":10001")
+	// 注册使用的URL由services.Run根据实际监听的地址生成
+	re := registry.RegistrationEntry{
+		ServiceName:      registry.LogService,
+		RequiredServices: []registry.ServiceName{},
+		ServiceUpdateURL: "/services",
+		HeartbeatURL:     "/health",
+	}
+	// 日志文件不可写时日志服务不能处理请求
+	health := services.NewHealth()
+	health.AddReadiness("log-file", services.FileWritable(destination))
+	done, err := services.Run(ctx, services.Config{
+		Host:             host,
+		Port:             port,
+		AdvertiseAddr:    opts.AdvertiseAddr,
+		Instance:         opts.Instance,
+		TLS:              opts.TLS,
+		Admin:            opts.Admin,
+		Registration:     re,
+		RegisterHandlers: log.RegisterHandlers,
+		IgnoreSignals:    true,
+		Tracing:          true,
+		Health:           health,
+		// 日志查询可能要扫描很多轮转的文件, 不缓冲它的响应
+		StreamRoutes: []string{"/log"},
+	})
+	if err != nil {
+		_ = log.Close()
+		return done, err
+	}
+	go func() {
+		<-done.Done()
+		// 服务关闭后不再有写入, 关闭日志文件
+		if err := log.Close(); err != nil {
+			stlog.Println("Failed to close log file:", err)
+		}
+	}()
+	return done, nil
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"DistributedGo/registry"
+	"DistributedGo/services"
+	"DistributedGo/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RegistryOptions 启动注册中心的配置
+type RegistryOptions struct {
+	Addr            string        // REST接口的监听地址, 为空时使用registry.ServerPort
+	RPCAddr         string        // JSON-RPC接口的监听地址, 为空时使用registry.RPCPort
+	ShutdownTimeout time.Duration // 关闭时等待进行中的请求结束的时间, 为0时使用默认值
+	// Admin 不为nil时启用管理接口, 用于排查推送和健康检查的goroutine泄漏
+	Admin *services.AdminConfig
+}
+
+// RunRegistry 启动注册中心, ctx结束时关闭. 返回时已经在监听, 之后启动的服务可以直接注册.
+// 返回的ctx在注册中心关闭后结束.
+func RunRegistry(ctx context.Context, opts RegistryOptions) (context.Context, error) {
+	if opts.Addr == "" {
+		opts.Addr = registry.ServerPort
+	}
+	if opts.RPCAddr == "" {
+		opts.RPCAddr = registry.RPCPort
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if opts.Admin != nil {
+		if err := opts.Admin.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 注册中心持有自己的ServeMux: /services 注册服务, /signals 服务上报的健康信号
+	server := registry.NewServer(registry.ServerOptions{})
+	server.StartHealthCheck()
+	// 健康检查和patch推送的span发往TracingService, 它的地址直接从注册列表中查找.
+	// 导出器只接收注册中心自己的span, 注册中心关闭时关闭它
+	exporter := tracing.NewHTTPExporter(func() (string, error) {
+		return server.Lookup(registry.TracingService)
+	}, nil)
+	unregister := tracing.RegisterExporter(string(registry.RegistryServiceName), exporter)
+	closeTracing := func() {
+		unregister()
+		exporter.Close()
+	}
+	// JSON-RPC接口与REST接口共用同一个注册中心
+	go func() {
+		if err := server.ServeRPC(opts.RPCAddr); !errors.Is(err, net.ErrClosed) {
+			log.Println(err)
+		}
+	}()
+
+	handler := server.Handler()
+	var admin *http.Server
+	if opts.Admin != nil {
+		adminHandler := services.AdminHandler(opts.Admin.Token, time.Now())
+		if opts.Admin.Addr != "" {
+			if admin, err = services.StartAdmin(opts.Admin.Addr, adminHandler); err != nil {
+				_ = listener.Close()
+				_ = server.Close()
+				closeTracing()
+				return nil, err
+			}
+		} else {
+			mux := http.NewServeMux()
+			mux.Handle("/", handler)
+			mux.Handle(services.AdminPrefix, adminHandler)
+			handler = mux
+		}
+	}
+
+	stopCtx, stop := context.WithCancel(ctx)
+	done, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{Handler: handler}
+
+	// 1. 启动该服务, 如果启动失败, 直接结束该服务
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+			stop()
+		}
+	}()
+	fmt.Printf("服务注册中心已启动, 监听地址%s\n", opts.Addr)
+
+	// 2. ctx结束后关闭该服务
+	go func() {
+		<-stopCtx.Done()
+		stop()
+		fmt.Println("正在关闭服务注册中心...")
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+		cancelShutdown()
+		_ = server.Close()
+		if admin != nil {
+			_ = admin.Close()
+		}
+		closeTracing()
+		cancel()
+	}()
+	return done, nil
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"DistributedGo/registry"
+	"DistributedGo/services"
+	"DistributedGo/tracing"
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// RunTracingService 启动TracingService, 收集其他服务发来的span, 按trace ID提供瀑布图JSON.
+func RunTracingService(ctx context.Context, opts ServiceOptions) (context.Context, error) {
+	host, port := opts.addr(":10003")
+	re := registry.RegistrationEntry{
+		ServiceName:      registry.TracingService,
+		RequiredServices: []registry.ServiceName{},
+		ServiceUpdateURL: "/services",
+		HeartbeatURL:     "/health",
+	}
+	collector := tracing.NewCollector()
+	return services.Run(ctx, services.Config{
+		Host:             host,
+		Port:             port,
+		AdvertiseAddr:    opts.AdvertiseAddr,
+		Instance:         opts.Instance,
+		TLS:              opts.TLS,
+		Admin:            opts.Admin,
+		Registration:     re,
+		RegisterHandlers: collector.RegisterHandlers,
+		IgnoreSignals:    true,
+		// 与默认的中间件链相同, 但不追踪自己, 否则接收span的请求又会产生新的span
+		Middleware: []services.Middleware{
+			services.RequestID(),
+			services.AccessLog(re.ServiceName, log.New(os.Stderr, "", log.LstdFlags)),
+			services.BodyLimit(4 << 20),
+			services.Timeout(10*time.Second, nil),
+			services.Recover(),
+		},
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"DistributedGo/registry"
+	"DistributedGo/services"
+	"DistributedGo/tracing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// gateway 对外的统一入口, 客户端不需要知道每个服务的地址和端口:
+// 1. 按路由配置把路径前缀映射到服务名, 例如 /api/grades/* -> GradingService;
+// 2. 网关作为依赖这些服务的客户端注册到注册中心, 长轮询注册列表, 实例集合变化时重新加载路由配置并更新每条路由的可用实例,
+//    路由没有可用实例时直接返回503;
+// 3. 转发通过registry.Transport完成, 负载均衡、熔断和重试与其他服务之间的调用一致;
+// 4. 统一处理CORS、请求ID和每条路由的超时时间;
+// 5. 每个请求记录一个server span, 转发时传递traceparent, 一次请求在所有服务中的span属于同一个trace.
+//
+// 路由配置文件是JSON数组, 例如:
+//
+//	[{"prefix": "/api/grades/", "service": "GradingService", "strip": true, "timeout": "5s"}]
+
+const (
+	// 网关自己使用的路径, 加上前缀避免和路由冲突
+	updatePath    = "/_gateway/services"
+	heartbeatPath = "/_gateway/health"
+	routesPath    = "/_gateway/routes"
+	metricsPath   = "/_gateway/metrics"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:10080", "网关的监听地址")
+	routesFile := flag.String("routes", "", "路由配置文件, 为空时使用默认路由 /api/grades/ -> GradingService")
+	origins := flag.String("cors-origins", "*", "允许跨域访问的来源, 逗号分隔, *表示所有来源")
+	insecure := flag.Bool("tls-insecure", false, "转发时不校验服务的证书, 服务使用自签名证书时需要")
+	flag.Parse()
+	if *insecure {
+		services.InsecureSkipVerify()
+	}
+
+	table, err := newRouteTable(*routesFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	client := registry.NewClient(registry.ClientOptions{MetricsPath: metricsPath})
+	serviceAddress := "http://" + *addr
+	re := registry.RegistrationEntry{
+		ServiceName:      registry.GatewayService,
+		ServiceURL:       serviceAddress,
+		RequiredServices: []registry.ServiceName{registry.TracingService},
+		LoadBalancing:    map[registry.ServiceName]registry.BalancerType{},
+		ServiceUpdateURL: serviceAddress + updatePath,
+		HeartbeatURL:     serviceAddress + heartbeatPath,
+	}
+	require(&re, table.services())
+
+	exporter := tracing.NewHTTPExporter(func() (string, error) {
+		return client.GetProvider(registry.TracingService)
+	}, func(url string, err error) {
+		client.Report(registry.TracingService, url, err)
+	})
+	defer exporter.Close()
+	defer tracing.RegisterExporter(string(registry.GatewayService), exporter)()
+
+	gw := &gateway{table: table, proxy: newProxy(client), origins: strings.Split(*origins, ",")}
+	client.Mux().HandleFunc(routesPath, gw.serveRoutes)
+	client.Mux().Handle("/", gw)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 先同步监听, 注册中心在注册时就会推送依赖信息, 注册之前网关必须已经可以接受连接
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	srv := &http.Server{Handler: services.Trace(registry.GatewayService)(client.Mux())}
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+			stop()
+		}
+	}()
+
+	if err := client.Register(re); err != nil {
+		log.Fatalln("注册网关失败:", err)
+	}
+	go watchRoutes(ctx, client, table, re)
+	fmt.Printf("网关已启动, 监听地址%s\n", *addr)
+	for _, route := range table.all() {
+		fmt.Printf("  %s -> %s (timeout %v)\n", route.Prefix, route.Service, time.Duration(route.Timeout))
+	}
+
+	<-ctx.Done()
+	fmt.Println("正在关闭网关...")
+	if err := client.Deregister(re); err != nil {
+		log.Println(err)
+	}
+	client.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+}
+
+// require 把路由指向的服务加入网关的依赖, 返回是否有新增的依赖
+func require(re *registry.RegistrationEntry, names []registry.ServiceName) bool {
+	added := false
+	for _, name := range names {
+		if _, ok := re.LoadBalancing[name]; !ok {
+			re.RequiredServices = append(re.RequiredServices, name)
+			re.LoadBalancing[name] = registry.RoundRobin
+			added = true
+		}
+	}
+	return added
+}
+
+// watchRoutes 长轮询注册中心的注册列表, 实例集合变化时重新加载路由. 路由配置中出现新的服务时, 带着新的依赖重新注册,
+// 注册中心随后推送这些服务的实例, 转发时才能选到它们.
+func watchRoutes(ctx context.Context, client *registry.Client, table *routeTable, re registry.RegistrationEntry) {
+	re.LoadBalancing = maps.Clone(re.LoadBalancing)
+	var version uint64
+	for {
+		reply, err := client.Watch(ctx, "", version)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("查询注册列表失败: %v, 稍后重试\n", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if reply.Version == version {
+			// 等待超时, 注册列表没有变化
+			continue
+		}
+		version = reply.Version
+		if err := table.reload(reply.Services); err != nil {
+			log.Printf("重新加载路由失败, 继续使用原来的路由: %v\n", err)
+		}
+		for _, route := range table.all() {
+			log.Printf("路由%s -> %s: 当前可用实例%v\n", route.Prefix, route.Service, route.Instances())
+		}
+		if require(&re, table.services()) {
+			if err := client.Register(re); err != nil {
+				log.Printf("更新网关的依赖失败: %v\n", err)
+			}
+		}
+	}
+}
+
+type gateway struct {
+	table   *routeTable
+	proxy   *httputil.ReverseProxy
+	origins []string
+}
+
+// routeKey 请求上下文中保存匹配到的路由
+type routeKey struct{}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(registry.RequestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		r.Header.Set(registry.RequestIDHeader, id)
+	}
+	w.Header().Set(registry.RequestIDHeader, id)
+
+	if g.cors(w, r) {
+		return
+	}
+	route := g.table.match(r.URL.Path)
+	if route == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !route.available() {
+		http.Error(w, fmt.Sprintf("服务%s没有可用的实例", route.Service), http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(route.Timeout))
+	defer cancel()
+	ctx = context.WithValue(ctx, routeKey{}, route)
+	g.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// cors 设置跨域响应头, 预检请求在这里直接返回, 返回值表示请求是否已经处理完
+func (g *gateway) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !g.allowOrigin(origin) {
+		return false
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Expose-Headers", registry.RequestIDHeader)
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	h.Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (g *gateway) allowOrigin(origin string) bool {
+	for _, o := range g.origins {
+		if o = strings.TrimSpace(o); o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRoutes 展示路由和每条路由当前可用的实例
+func (g *gateway) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	type routeInfo struct {
+		*Route
+		Instances []string `json:"instances"`
+	}
+	routes := g.table.all()
+	infos := make([]routeInfo, 0, len(routes))
+	for _, route := range routes {
+		infos = append(infos, routeInfo{Route: route, Instances: route.Instances()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(infos)
+}
+
+// newProxy 把请求改写为 http://<服务名>/<路径>, 由registry.Transport选择实例
+func newProxy(client *registry.Client) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: &registry.Transport{Client: client},
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			route := pr.In.Context().Value(routeKey{}).(*Route)
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = string(route.Service)
+			pr.Out.URL.Path = route.rewritePath(pr.In.URL.Path)
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = ""
+			pr.SetXForwarded()
+			pr.Out.Header.Set(registry.RequestIDHeader, pr.In.Header.Get(registry.RequestIDHeader))
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			status := http.StatusBadGateway
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				status = http.StatusGatewayTimeout
+			case errors.Is(err, registry.ErrCircuitOpen):
+				status = http.StatusServiceUnavailable
+			}
+			log.Printf("[%s] %s %s 转发失败: %v\n", r.Header.Get(registry.RequestIDHeader), r.Method, r.URL.Path, err)
+			http.Error(w, http.StatusText(status), status)
+		},
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
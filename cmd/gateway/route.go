@@ -0,0 +1,189 @@
+package main
+
+import (
+	"DistributedGo/registry"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route 一条路由规则: 以Prefix开头的请求转发给Service的实例
+type Route struct {
+	Prefix  string               `json:"prefix"`  // 路径前缀, 例如 /api/grades/
+	Service registry.ServiceName `json:"service"` // 目标服务名
+	Strip   bool                 `json:"strip"`   // 转发前是否去掉前缀, /api/grades/students -> /students
+	Timeout duration             `json:"timeout"` // 单个请求的超时时间, 为0时使用默认值
+
+	instances map[string]bool // 当前可用的实例, 取自注册中心的注册列表
+	mutex     *sync.RWMutex
+}
+
+const defaultRouteTimeout = 10 * time.Second
+
+// defaultRoutes 没有指定路由配置文件时使用的路由
+var defaultRoutes = []*Route{
+	{Prefix: "/api/grades/", Service: registry.GradingService, Strip: true},
+}
+
+// duration 在JSON中以"5s"这样的字符串表示的时间
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadRoutes 读取路由配置文件, 文件内容是Route的JSON数组. 前缀长的路由优先匹配.
+// 每次都返回新的Route, 重新加载时不会改动正在使用的路由.
+func loadRoutes(file string) ([]*Route, error) {
+	var routes []*Route
+	for _, r := range defaultRoutes {
+		route := *r
+		routes = append(routes, &route)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		routes = nil
+		if err := json.Unmarshal(data, &routes); err != nil {
+			return nil, fmt.Errorf("路由配置解析失败: %w", err)
+		}
+	}
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Prefix, "/") || r.Service == "" {
+			return nil, fmt.Errorf("路由配置无效: prefix=%q service=%q", r.Prefix, r.Service)
+		}
+		if r.Timeout <= 0 {
+			r.Timeout = duration(defaultRouteTimeout)
+		}
+		r.instances = make(map[string]bool)
+		r.mutex = &sync.RWMutex{}
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) > len(routes[j].Prefix) })
+	return routes, nil
+}
+
+// match 返回请求路径匹配的路由
+func match(routes []*Route, path string) *Route {
+	for _, r := range routes {
+		if strings.HasPrefix(path, r.Prefix) || path == strings.TrimSuffix(r.Prefix, "/") {
+			return r
+		}
+	}
+	return nil
+}
+
+// setInstances 用注册列表中目标服务的实例替换路由的可用实例
+func (r *Route) setInstances(services []registry.RegistrationEntry) {
+	instances := make(map[string]bool)
+	for _, re := range services {
+		if re.ServiceName == r.Service {
+			instances[re.ServiceURL] = true
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.instances = instances
+}
+
+func (r *Route) available() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.instances) > 0
+}
+
+// Instances 当前可用的实例, 供/_gateway/routes展示
+func (r *Route) Instances() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	urls := make([]string, 0, len(r.instances))
+	for url := range r.instances {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// rewritePath 去掉前缀后的转发路径
+func (r *Route) rewritePath(path string) string {
+	if !r.Strip {
+		return path
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, strings.TrimSuffix(r.Prefix, "/")), "/")
+	return "/" + path
+}
+
+// routeTable 网关当前使用的路由. 注册中心的实例集合变化时重新读取路由配置, 并用注册列表更新每条路由的可用实例,
+// 修改路由配置文件后不需要重启网关.
+type routeTable struct {
+	file   string
+	routes []*Route
+	mutex  *sync.RWMutex
+}
+
+func newRouteTable(file string) (*routeTable, error) {
+	routes, err := loadRoutes(file)
+	if err != nil {
+		return nil, err
+	}
+	return &routeTable{file: file, routes: routes, mutex: &sync.RWMutex{}}, nil
+}
+
+func (t *routeTable) match(path string) *Route {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return match(t.routes, path)
+}
+
+func (t *routeTable) all() []*Route {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.routes
+}
+
+// services 路由指向的服务, 不重复
+func (t *routeTable) services() []registry.ServiceName {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	var names []registry.ServiceName
+	for _, r := range t.routes {
+		if !slices.Contains(names, r.Service) {
+			names = append(names, r.Service)
+		}
+	}
+	return names
+}
+
+// reload 重新读取路由配置, 路由的可用实例取自注册列表services. 配置读取失败时返回错误, 保留原来的路由, 只更新实例.
+func (t *routeTable) reload(services []registry.RegistrationEntry) error {
+	routes, err := loadRoutes(t.file)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		routes = t.routes
+	}
+	for _, r := range routes {
+		r.setInstances(services)
+	}
+	t.routes = routes
+	return err
+}
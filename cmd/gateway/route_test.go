@@ -0,0 +1,145 @@
+package main
+
+import (
+	"DistributedGo/registry"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func testRoutes(t *testing.T) []*Route {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "routes.json")
+	data := `[
+		{"prefix": "/api/", "service": "Api"},
+		{"prefix": "/api/grades/", "service": "GradingService", "strip": true},
+		{"prefix": "/api/grades/admin/", "service": "Admin", "strip": true}
+	]`
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := loadRoutes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+func TestMatch(t *testing.T) {
+	routes := testRoutes(t)
+	tests := []struct {
+		path string
+		want registry.ServiceName // 为空表示没有匹配的路由
+	}{
+		{"/api/grades/students/1", "GradingService"},
+		{"/api/grades/", "GradingService"},
+		{"/api/grades", "GradingService"}, // 没有结尾斜杠的前缀本身
+		{"/api/grades/admin/users", "Admin"},
+		{"/api/gradesx", "Api"}, // 前缀按路径段匹配, 落到更短的/api/
+		{"/api/other", "Api"},
+		{"/apix", ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var got registry.ServiceName
+			if r := match(routes, tt.path); r != nil {
+				got = r.Service
+			}
+			if got != tt.want {
+				t.Fatalf("%s匹配到%q, 期望%q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		route Route
+		path  string
+		want  string
+	}{
+		{Route{Prefix: "/api/grades/", Strip: true}, "/api/grades/students/1", "/students/1"},
+		{Route{Prefix: "/api/grades/", Strip: true}, "/api/grades/", "/"},
+		{Route{Prefix: "/api/grades/", Strip: true}, "/api/grades", "/"},
+		{Route{Prefix: "/api/grades", Strip: true}, "/api/grades/students", "/students"},
+		{Route{Prefix: "/api/grades/", Strip: false}, "/api/grades/students/1", "/api/grades/students/1"},
+	}
+	for _, tt := range tests {
+		if got := tt.route.rewritePath(tt.path); got != tt.want {
+			t.Errorf("%+v: %s改写为%s, 期望%s", tt.route, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestRouteTableReload 注册列表变化时重新读取路由配置, 路由的可用实例来自注册列表
+func TestRouteTableReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.json")
+	write := func(data string) {
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"prefix": "/api/grades/", "service": "GradingService", "strip": true}]`)
+	table, err := newRouteTable(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []registry.RegistrationEntry{
+		{ServiceName: "GradingService", ServiceURL: "http://g1"},
+		{ServiceName: "GradingService", ServiceURL: "http://g2"},
+		{ServiceName: "LogService", ServiceURL: "http://l1"},
+	}
+	if err := table.reload(entries); err != nil {
+		t.Fatal(err)
+	}
+	if got := table.match("/api/grades/x").Instances(); !slices.Equal(got, []string{"http://g1", "http://g2"}) {
+		t.Fatalf("路由的实例为%v", got)
+	}
+
+	// 修改配置文件后, 下一次注册列表变化时加载新的路由
+	write(`[{"prefix": "/api/grades/", "service": "GradingService"}, {"prefix": "/api/logs/", "service": "LogService"}]`)
+	if err := table.reload(entries[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if got := table.match("/api/grades/x").Instances(); !slices.Equal(got, []string{"http://g2"}) {
+		t.Fatalf("下线的实例应该从路由中删除, 得到%v", got)
+	}
+	if r := table.match("/api/logs/x"); r == nil || !slices.Equal(r.Instances(), []string{"http://l1"}) {
+		t.Fatalf("应该加载新的路由/api/logs/, 得到%+v", r)
+	}
+	if got := table.services(); !slices.Equal(got, []registry.ServiceName{"GradingService", "LogService"}) {
+		t.Fatalf("路由指向的服务为%v", got)
+	}
+
+	// 配置文件无效时保留原来的路由, 只更新实例
+	write(`not json`)
+	if err := table.reload(entries[2:]); err == nil {
+		t.Fatal("配置无效时应该返回错误")
+	}
+	if r := table.match("/api/logs/x"); r == nil || len(table.match("/api/grades/x").Instances()) != 0 {
+		t.Fatal("配置无效时应该保留原来的路由并更新实例")
+	}
+}
+
+// TestGatewayStatus 没有路由时返回404, 路由没有可用实例时返回503
+func TestGatewayStatus(t *testing.T) {
+	table, err := newRouteTable("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := &gateway{table: table}
+	for path, want := range map[string]int{"/unknown": http.StatusNotFound, "/api/grades/students": http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s返回%d, 期望%d", path, rec.Code, want)
+		}
+		if rec.Header().Get(registry.RequestIDHeader) == "" {
+			t.Errorf("%s的响应没有请求ID", path)
+		}
+	}
+}
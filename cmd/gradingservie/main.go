@@ -1,36 +1,31 @@
 package main
 
 import (
-	"DistributedGo/grades"
-	"DistributedGo/log"
-	"DistributedGo/registry"
-	"DistributedGo/services"
-	"context"
+	"DistributedGo/app"
+	"flag"
 	"fmt"
 	stlog "log"
 )
 
 func main() {
-	host, port := "localhost", ":10002"
-	serviceAddress := fmt.Sprintf("http://%v%v", host, port)
-	re := registry.RegistrationEntry{
-		ServiceName:      registry.GradingService,
-		ServiceURL:       serviceAddress,
-		RequiredServices: []registry.ServiceName{registry.LogService},
-		ServiceUpdateURL: serviceAddress + "/services",
-		HeartbeatURL:     serviceAddress + "/health",
-	}
-	ctx, err := services.Start(context.Background(), host, port, re, grades.RegisterHandler)
+	stdin := flag.Bool("stdin", false, "允许在标准输入中输入任意内容关闭服务")
+	var opts app.ServiceOptions
+	flag.StringVar(&opts.Host, "host", "localhost", "监听的主机")
+	flag.StringVar(&opts.Port, "port", ":10002", "监听的端口, :0表示由系统分配")
+	flag.StringVar(&opts.AdvertiseAddr, "advertise", "", "注册到注册中心的地址, 为空时使用监听的主机和实际的端口")
+	flag.StringVar(&opts.Instance, "instance", "", "实例后缀, 同时启动多个实例时用来区分")
+	tlsConfig := app.TLSFlags()
+	adminConfig := app.AdminFlags()
+	flag.Parse()
+	opts.TLS, opts.Admin = tlsConfig(), adminConfig()
+
+	ctx, stop := app.ShutdownContext(*stdin)
+	defer stop()
+
+	done, err := app.RunGradingService(ctx, opts)
 	if err != nil {
 		stlog.Fatalf("failed to start service: %v", err)
 	}
-
-	if logProvider, err := registry.GetProvider(registry.LogService); err == nil {
-		fmt.Println("Log service provider found: ", logProvider)
-		log.SetClientLogger(logProvider+"/log", re.ServiceName)
-		stlog.Println("Log service provider found: ", re.ServiceName)
-	}
-
-	<-ctx.Done()
-	fmt.Printf("服务[%s]已关闭", re.ServiceName)
+	<-done.Done()
+	fmt.Println("服务[GradingService]已关闭")
 }
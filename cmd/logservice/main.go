@@ -1,30 +1,33 @@
 package main
 
 import (
-	"DistributedGo/log"
-	"DistributedGo/registry"
-	"DistributedGo/services"
-	"context"
+	"DistributedGo/app"
+	"flag"
 	"fmt"
 	stlog "log"
 )
 
 func main() {
-	log.Run("distributed_go.log") // 初始化日志服务, 指定日志文件路径
-	host, port := "localhost", ":10001"
-	serviceAddress := fmt.Sprintf("http://%v%v", host, port)
-	re := registry.RegistrationEntry{
-		ServiceName:      registry.LogService,
-		ServiceURL:       serviceAddress,
-		RequiredServices: []registry.ServiceName{},
-		ServiceUpdateURL: serviceAddress + "/services",
-		HeartbeatURL:     serviceAddress + "/health",
-	}
-	ctx, err := services.Start(context.Background(), host, port, re, log.RegisterHandlers)
+	stdin := flag.Bool("stdin", false, "允许在标准输入中输入任意内容关闭服务")
+	var opts app.ServiceOptions
+	flag.StringVar(&opts.Host, "host", "localhost", "监听的主机")
+	flag.StringVar(&opts.Port, "port", ":10001", "监听的端口, :0表示由系统分配")
+	flag.StringVar(&opts.AdvertiseAddr, "advertise", "", "注册到注册中心的地址, 为空时使用监听的主机和实际的端口")
+	flag.StringVar(&opts.Instance, "instance", "", "实例后缀, 同时启动多个实例时用来区分")
+	tlsConfig := app.TLSFlags()
+	adminConfig := app.AdminFlags()
+	rotateConfig := app.LogRotateFlags()
+	flag.Parse()
+	opts.TLS, opts.Admin = tlsConfig(), adminConfig()
+
+	ctx, stop := app.ShutdownContext(*stdin)
+	defer stop()
+
+	done, err := app.RunLogService(ctx, "distributed_go.log", rotateConfig(), opts)
 	if err != nil {
 		stlog.Fatalln("启动服务失败:", err) // 此时自定义的日志服务还没有启动, 所以使用标准日志输出
 		return
 	}
-	<-ctx.Done() // 等待服务结束的信号
-	fmt.Printf("服务[%s]已关闭", re.ServiceName)
+	<-done.Done() // 等待服务结束的信号
+	fmt.Println("服务[LogService]已关闭")
 }
@@ -1,42 +1,33 @@
 package main
 
 import (
-	"DistributedGo/registry"
-	"context"
+	"DistributedGo/app"
+	"DistributedGo/services"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
 )
 
 // 服务注册这个服务与其他被注册服务不一样. 服务注册类似于后端的服务, 被注册的服务类似客户端的服务.
-// 这里的逻辑类似于service.service.go中的逻辑.
+// 启动逻辑在app.RunRegistry中, 根目录的main.go也使用它.
 func main() {
-	registry.StartHealthCheck()
-	http.Handle("/services", &registry.RegistryService{}) // 注册服务注册处理器
+	stdin := flag.Bool("stdin", false, "允许在标准输入中输入任意内容关闭注册中心")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 0, "关闭时等待进行中的请求结束的时间, 为0时使用默认值")
+	adminConfig := app.AdminFlags()
+	insecure := flag.Bool("tls-insecure", false, "健康检查和推送时不校验服务的证书, 服务使用自签名证书时需要")
+	flag.Parse()
+	if *insecure {
+		services.InsecureSkipVerify()
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// 收到SIGINT/SIGTERM时关闭, 在systemd或者后台任务中也能正常退出
+	ctx, stop := app.ShutdownContext(*stdin)
+	defer stop()
 
-	var srv http.Server
-	srv.Addr = registry.ServerPort
-
-	// 1. 启动该服务, 如果启动失败, 直接结束该服务
-	go func() {
-		log.Println(srv.ListenAndServe())
-		cancel()
-	}()
-
-	// 2. 手动关闭该服务
-	go func() {
-		fmt.Printf("服务注册中心已启动, 监听地址%s\n", registry.ServerPort)
-		fmt.Printf("按任意键退出服务注册中心...\n")
-		var s string
-		_, _ = fmt.Scan(&s)
-		fmt.Println("正在关闭服务注册中心...")
-		_ = srv.Shutdown(ctx)
-		cancel()
-
-	}()
-	<-ctx.Done()
+	done, err := app.RunRegistry(ctx, app.RegistryOptions{ShutdownTimeout: *shutdownTimeout, Admin: adminConfig()})
+	if err != nil {
+		log.Fatalln("启动服务注册中心失败:", err)
+	}
+	<-done.Done()
 	fmt.Println("服务注册中心已关闭")
 }
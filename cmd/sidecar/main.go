@@ -0,0 +1,146 @@
+package main
+
+import (
+	"DistributedGo/registry"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sidecar 代替非Go的进程(Python、Node等)接入注册中心, 它们不需要移植registry/client.go:
+// 1. 入站: 以-name注册到注册中心, ServiceURL是sidecar的入站地址. 注册中心的更新通知和健康检查由sidecar处理,
+//    健康检查会转发到本地进程的健康检查接口; 其他请求原样转发给本地进程.
+// 2. 出站: 本地进程请求 http://localhost:<出站端口>/<服务名>/<路径>, sidecar通过providers选择实例,
+//    带负载均衡、熔断和重试地转发给依赖的服务.
+//
+// 例如: sidecar -name ReportService -app http://localhost:5000 -requires GradingService,LogService
+
+// sidecar自己使用的路径, 加上前缀避免和本地进程的路由冲突
+const (
+	updatePath    = "/_sidecar/services"
+	heartbeatPath = "/_sidecar/health"
+	metricsPath   = "/_sidecar/metrics"
+)
+
+func main() {
+	name := flag.String("name", "", "注册到注册中心的服务名(必填)")
+	app := flag.String("app", "http://localhost:5000", "本地进程的地址")
+	appHealth := flag.String("app-health", "/health", "本地进程的健康检查路径, 返回2xx表示健康")
+	inbound := flag.String("inbound", "localhost:10020", "入站监听地址, 其他服务通过它访问本地进程")
+	outbound := flag.String("outbound", "localhost:10021", "出站监听地址, 本地进程通过它访问依赖的服务")
+	requires := flag.String("requires", "", "依赖的服务, 逗号分隔")
+	balancer := flag.String("balancer", string(registry.RoundRobin), "依赖服务的负载均衡策略")
+	flag.Parse()
+	if *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	appURL, err := url.Parse(*app)
+	if err != nil {
+		log.Fatalln("本地进程地址解析失败:", err)
+	}
+
+	client := registry.NewClient(registry.ClientOptions{Heartbeat: appHealthHandler(*app + *appHealth), MetricsPath: metricsPath})
+	// 入站: sidecar的路径之外的请求都转发给本地进程
+	client.Mux().Handle("/", httputil.NewSingleHostReverseProxy(appURL))
+
+	serviceAddress := "http://" + *inbound
+	re := registry.RegistrationEntry{
+		ServiceName:      registry.ServiceName(*name),
+		ServiceURL:       serviceAddress,
+		RequiredServices: []registry.ServiceName{},
+		LoadBalancing:    map[registry.ServiceName]registry.BalancerType{},
+		ServiceUpdateURL: serviceAddress + updatePath,
+		HeartbeatURL:     serviceAddress + heartbeatPath,
+	}
+	for _, s := range strings.Split(*requires, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			re.RequiredServices = append(re.RequiredServices, registry.ServiceName(s))
+			re.LoadBalancing[registry.ServiceName(s)] = registry.BalancerType(*balancer)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 先同步监听, 注册中心在注册时就会推送依赖信息, 注册之前入站地址必须已经可以接受连接
+	inboundLn, err := net.Listen("tcp", *inbound)
+	if err != nil {
+		log.Fatalln("入站监听失败:", err)
+	}
+	outboundLn, err := net.Listen("tcp", *outbound)
+	if err != nil {
+		log.Fatalln("出站监听失败:", err)
+	}
+	inboundSrv := &http.Server{Handler: client.Mux()}
+	outboundSrv := &http.Server{Handler: outboundProxy(client)}
+	for srv, ln := range map[*http.Server]net.Listener{inboundSrv: inboundLn, outboundSrv: outboundLn} {
+		go func() {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				log.Println(err)
+				stop()
+			}
+		}()
+	}
+
+	if err := client.Register(re); err != nil {
+		log.Fatalln("注册服务失败:", err)
+	}
+	fmt.Printf("sidecar[%s]已启动, 入站%s -> %s, 出站%s\n", *name, *inbound, *app, *outbound)
+
+	<-ctx.Done()
+	fmt.Printf("正在关闭sidecar[%s]...\n", *name)
+	if err := client.Deregister(re); err != nil {
+		log.Println(err)
+	}
+	client.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = inboundSrv.Shutdown(shutdownCtx)
+	_ = outboundSrv.Shutdown(shutdownCtx)
+}
+
+// appHealthHandler 把注册中心的健康检查转发到本地进程, 本地进程不可用或者返回非2xx时返回503
+func appHealthHandler(healthURL string) http.Handler {
+	httpClient := &http.Client{Timeout: time.Second}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := httpClient.Get(healthURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_ = res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			http.Error(w, fmt.Sprintf("app health status %d", res.StatusCode), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// outboundProxy 把 /<服务名>/<路径> 转发到服务名对应的实例, 由registry.Transport完成服务发现、负载均衡和重试
+func outboundProxy(client *registry.Client) http.Handler {
+	return &httputil.ReverseProxy{
+		Transport: &registry.Transport{Client: client},
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			service, path, _ := strings.Cut(strings.TrimPrefix(pr.In.URL.Path, "/"), "/")
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = service
+			pr.Out.URL.Path = "/" + path
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = ""
+			pr.SetXForwarded()
+		},
+	}
+}
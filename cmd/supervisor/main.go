@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// supervisor 按清单启动和管理整个系统的进程, 不需要再打开多个终端按顺序手动启动:
+// 1. 按依赖顺序启动服务, 一个服务的所有实例就绪后再启动依赖它的服务;
+// 2. 子进程的输出加上实例名前缀后输出到标准输出, 也可以写入-log-dir下每个实例的文件;
+// 3. 进程意外退出时按指数退避重启, 运行稳定后退避时间重新计算;
+// 4. 收到SIGHUP或者POST /restart时滚动重启: 按依赖顺序逐个重启实例, 上一个就绪后再重启下一个;
+// 5. 收到SIGINT/SIGTERM时按依赖关系的逆序停止, 例如 成绩服务 -> 日志服务 -> 注册中心.
+//
+// 清单文件是Service的JSON数组, 例如:
+//
+//	[{"name": "registry", "command": ["registerservice"], "ready": "http://localhost:10000/services"},
+//	 {"name": "grading", "command": ["gradingservie", "-port", ":0", "-instance", "{instance}"], "instances": 2, "depends": ["registry"]}]
+
+func main() {
+	manifestFile := flag.String("manifest", "", "服务清单文件, 为空时启动注册中心、日志服务和成绩服务")
+	bin := flag.String("bin", "", "查找服务命令的目录, 为空时在PATH中查找")
+	logDir := flag.String("log-dir", "", "每个实例的输出同时写入这个目录下的<实例名>.log")
+	control := flag.String("control", "localhost:10090", "控制接口的监听地址, 为空时不启动")
+	flag.Parse()
+
+	services, err := loadManifest(*manifestFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	out, err := newOutput(os.Stdout, *logDir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer out.close()
+	sup := &supervisor{services: services, mutex: &sync.Mutex{}}
+	for _, s := range services {
+		for i := 1; i <= s.Instances; i++ {
+			in, err := newInstance(s, i, *bin, out)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			s.instances = append(s.instances, in)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	if *control != "" {
+		srv := &http.Server{Addr: *control, Handler: sup.handler()}
+		go func() {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Println("控制接口启动失败:", err)
+			}
+		}()
+		defer func() { _ = srv.Close() }()
+	}
+
+	sup.start(ctx)
+	for {
+		select {
+		case <-hup:
+			go func() {
+				if err := sup.rollingRestart(""); err != nil {
+					log.Println(err)
+				}
+			}()
+		case <-ctx.Done():
+			fmt.Println("正在停止所有服务...")
+			sup.stop()
+			fmt.Println("所有服务已停止")
+			return
+		}
+	}
+}
+
+type supervisor struct {
+	services []*Service // 按依赖顺序排列
+	// mutex 保证同一时间只有一个滚动重启或停止在进行
+	mutex *sync.Mutex
+}
+
+// start 按依赖顺序启动服务, 等待每个服务的实例就绪后再启动下一个服务. ctx结束时停止等待, 不再启动剩下的服务.
+func (s *supervisor) start(ctx context.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, svc := range s.services {
+		if ctx.Err() != nil {
+			return
+		}
+		for _, in := range svc.instances {
+			in.launch()
+		}
+		for _, in := range svc.instances {
+			if ctx.Err() != nil {
+				return
+			}
+			// 依赖的服务没有就绪时仍然继续启动, 服务自己会等待依赖的服务
+			if err := in.waitReady(ctx); err != nil && ctx.Err() == nil {
+				in.output.printf(in.name, "%v\n", err)
+			}
+		}
+	}
+}
+
+// stop 按依赖关系的逆序停止服务, 同一个服务的实例同时停止. 启动过程中被中断时, 还没有启动的实例直接标记为停止
+func (s *supervisor) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, svc := range slices.Backward(s.services) {
+		var wg sync.WaitGroup
+		for _, in := range svc.instances {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				in.stopProcess()
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// rollingRestart 按依赖顺序逐个重启实例, 上一个实例就绪后再重启下一个. name不为空时只重启这个服务.
+func (s *supervisor) rollingRestart(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	found := false
+	for _, svc := range s.services {
+		if name != "" && svc.Name != name {
+			continue
+		}
+		found = true
+		for _, in := range svc.instances {
+			in.output.printf(in.name, "滚动重启\n")
+			if err := in.restartProcess(); err != nil {
+				return fmt.Errorf("滚动重启在%s停止: %w", in.name, err)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("服务不存在: %s", name)
+	}
+	return nil
+}
+
+func (s *supervisor) statuses() []status {
+	var list []status
+	for _, svc := range s.services {
+		for _, in := range svc.instances {
+			list = append(list, in.status())
+		}
+	}
+	return list
+}
+
+// handler 控制接口:
+// GET  /status                  所有实例的状态
+// POST /restart?service=<name>  滚动重启, 不指定service时重启所有服务. 重启完成后返回
+func (s *supervisor) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.statuses())
+	})
+	mux.HandleFunc("/restart", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		start := time.Now()
+		if err := s.rollingRestart(r.URL.Query().Get("service")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "滚动重启完成, 用时%v\n", time.Since(start).Round(time.Millisecond))
+	})
+	return mux
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Service 清单中的一个服务. Command和Ready中的{instance}替换为实例序号(从1开始),
+// 同一个服务启动多个实例时用它区分端口或实例后缀, 例如:
+//
+//	{"name": "grading", "command": ["gradingservie", "-port", ":0", "-instance", "{instance}"], "instances": 2, "depends": ["log"]}
+type Service struct {
+	Name      string   `json:"name"`
+	Command   []string `json:"command"`   // 命令和参数, 命令在-bin目录中查找, 没有指定-bin时在PATH中查找
+	Instances int      `json:"instances"` // 实例数量, 为0时启动1个
+	Depends   []string `json:"depends"`   // 依赖的服务, 这些服务就绪后才启动本服务, 停止时本服务先停止
+	Ready     string   `json:"ready"`     // 就绪检查的URL, 返回200表示就绪; 为空时启动后等待一小段时间
+
+	instances []*instance
+}
+
+// defaultManifest 没有指定清单文件时使用的清单: 注册中心 -> 日志服务 -> 成绩服务
+var defaultManifest = []*Service{
+	{Name: "registry", Command: []string{"registerservice"}, Ready: "http://localhost:10000/services"},
+	{Name: "log", Command: []string{"logservice"}, Depends: []string{"registry"}, Ready: "http://localhost:10001/health/ready"},
+	{Name: "grading", Command: []string{"gradingservie"}, Depends: []string{"log"}, Ready: "http://localhost:10002/health/ready"},
+}
+
+// loadManifest 读取清单文件, 文件内容是Service的JSON数组. 返回的服务按依赖顺序排列, 被依赖的在前.
+func loadManifest(file string) ([]*Service, error) {
+	services := defaultManifest
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		services = nil
+		if err := json.Unmarshal(data, &services); err != nil {
+			return nil, fmt.Errorf("服务清单解析失败: %w", err)
+		}
+	}
+	for _, s := range services {
+		if s.Name == "" || len(s.Command) == 0 {
+			return nil, fmt.Errorf("服务清单无效: name=%q command=%q", s.Name, s.Command)
+		}
+		if s.Instances <= 0 {
+			s.Instances = 1
+		}
+	}
+	return sortByDependency(services)
+}
+
+// sortByDependency 拓扑排序, 依赖不存在或者循环依赖时返回错误. 没有依赖关系的服务保持清单中的顺序.
+func sortByDependency(services []*Service) ([]*Service, error) {
+	byName := make(map[string]*Service, len(services))
+	for _, s := range services {
+		if _, ok := byName[s.Name]; ok {
+			return nil, fmt.Errorf("服务重复: %s", s.Name)
+		}
+		byName[s.Name] = s
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(services))
+	sorted := make([]*Service, 0, len(services))
+	var visit func(s *Service, path []string) error
+	visit = func(s *Service, path []string) error {
+		switch state[s.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("循环依赖: %s", strings.Join(append(path, s.Name), " -> "))
+		}
+		state[s.Name] = visiting
+		for _, name := range s.Depends {
+			dep, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("服务%s依赖的服务%s不在清单中", s.Name, name)
+			}
+			if err := visit(dep, append(path, s.Name)); err != nil {
+				return err
+			}
+		}
+		state[s.Name] = visited
+		sorted = append(sorted, s)
+		return nil
+	}
+	for _, s := range services {
+		if err := visit(s, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// expand 把{instance}替换为实例序号
+func expand(s string, index int) string {
+	return strings.ReplaceAll(s, "{instance}", strconv.Itoa(index))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortByDependency(t *testing.T) {
+	svc := func(name string, depends ...string) *Service {
+		return &Service{Name: name, Depends: depends}
+	}
+	tests := []struct {
+		name     string
+		services []*Service
+		want     string // 排序后的服务名
+		err      string // 错误信息中包含的内容
+	}{
+		{"没有依赖保持顺序", []*Service{svc("a"), svc("b"), svc("c")}, "a,b,c", ""},
+		{"依赖排在前面", []*Service{svc("grading", "log"), svc("log", "registry"), svc("registry")}, "registry,log,grading", ""},
+		{"多个依赖", []*Service{svc("c", "a", "b"), svc("b"), svc("a")}, "a,b,c", ""},
+		{"默认清单", defaultManifest, "registry,log,grading", ""},
+		{"依赖不存在", []*Service{svc("a", "x")}, "", "x不在清单中"},
+		{"循环依赖", []*Service{svc("a", "b"), svc("b", "c"), svc("c", "a")}, "", "a -> b -> c -> a"},
+		{"依赖自己", []*Service{svc("a", "a")}, "", "循环依赖"},
+		{"服务重复", []*Service{svc("a"), svc("a")}, "", "服务重复"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := sortByDependency(tt.services)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("错误为%v, 期望包含%q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, s := range sorted {
+				names = append(names, s.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Fatalf("排序结果%s, 期望%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		s     string
+		index int
+		want  string
+	}{
+		{"-instance={instance}", 2, "-instance=2"},
+		{"http://localhost:1000{instance}/health", 3, "http://localhost:10003/health"},
+		{"no placeholder", 1, "no placeholder"},
+	}
+	for _, tt := range tests {
+		if got := expand(tt.s, tt.index); got != tt.want {
+			t.Errorf("expand(%q, %d) = %q, 期望%q", tt.s, tt.index, got, tt.want)
+		}
+	}
+}
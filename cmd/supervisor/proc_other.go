@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+func detach(cmd *exec.Cmd) {}
+
+// terminate 没有SIGTERM的平台上只能直接结束进程
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
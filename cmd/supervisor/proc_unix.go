@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach 让子进程使用自己的进程组. 在终端中按Ctrl-C时信号只发给supervisor,
+// 由supervisor按依赖关系的逆序逐个停止子进程.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate 请求进程退出, 服务收到SIGTERM后注销并等待进行中的请求结束
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
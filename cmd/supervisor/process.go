@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff   = time.Second      // 崩溃后第一次重启前的等待时间, 之后每次翻倍
+	maxBackoff   = 30 * time.Second // 重启等待时间的上限
+	stableAfter  = 10 * time.Second // 进程运行超过这个时间再退出时, 重启等待时间从头开始计算
+	stopTimeout  = 15 * time.Second // 发出SIGTERM后等待进程退出的时间, 超过后强制结束. 服务关闭时需要注销和等待请求结束
+	readyTimeout = 30 * time.Second // 等待实例就绪的最长时间
+	startDelay   = time.Second      // 没有就绪检查URL时, 启动后等待的时间
+)
+
+// instance 服务的一个实例, 对应一个子进程. supervise负责在进程崩溃后重启它.
+type instance struct {
+	service *Service
+	index   int
+	name    string // 例如"grading-2"
+	path    string // 命令的完整路径
+	args    []string
+	ready   string
+	output  *output
+
+	mutex     *sync.Mutex
+	cmd       *exec.Cmd
+	exited    chan struct{} // 当前进程退出时关闭
+	startedAt time.Time
+	restarts  int
+	restart   bool          // 滚动重启: 进程退出后立即重启, 不计入崩溃
+	started   bool          // supervise是否已经启动, 启动过程中被停止的服务不会启动
+	stopped   bool          // 已经停止, 进程退出后不再重启
+	stop      chan struct{} // 停止时关闭, 中断重启前的等待
+	done      chan struct{} // supervise结束时关闭; 没有启动过的实例在停止时关闭
+}
+
+func newInstance(s *Service, index int, bin string, out *output) (*instance, error) {
+	name := s.Name
+	if s.Instances > 1 {
+		name = fmt.Sprintf("%s-%d", s.Name, index)
+	}
+	command := expand(s.Command[0], index)
+	if bin != "" && filepath.Base(command) == command {
+		command = filepath.Join(bin, command)
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("服务%s的命令不存在: %w", s.Name, err)
+	}
+	args := make([]string, 0, len(s.Command)-1)
+	for _, a := range s.Command[1:] {
+		args = append(args, expand(a, index))
+	}
+	return &instance{
+		service: s,
+		index:   index,
+		name:    name,
+		path:    path,
+		args:    args,
+		ready:   expand(s.Ready, index),
+		output:  out,
+		mutex:   &sync.Mutex{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// start 启动一个新的进程, 进程的标准输出和标准错误加上实例名前缀后写入output
+func (in *instance) start() error {
+	cmd := exec.Command(in.path, in.args...)
+	detach(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	in.mutex.Lock()
+	in.cmd, in.exited, in.startedAt = cmd, exited, time.Now()
+	in.mutex.Unlock()
+	in.output.printf(in.name, "已启动, pid %d\n", cmd.Process.Pid)
+
+	go func() {
+		// 读完输出之后才能调用Wait, Wait会关闭管道
+		var wg sync.WaitGroup
+		for _, r := range []io.Reader{stdout, stderr} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				in.output.copy(in.name, r)
+			}()
+		}
+		wg.Wait()
+		err := cmd.Wait()
+		in.output.printf(in.name, "已退出: %v\n", exitStatus(err))
+		close(exited)
+	}()
+	return nil
+}
+
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// launch 在新的goroutine中运行supervise. 实例已经停止时不再启动
+func (in *instance) launch() {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	if in.started || in.stopped {
+		return
+	}
+	in.started = true
+	go in.supervise()
+}
+
+// supervise 启动进程并在它意外退出后按指数退避重启, 直到调用stopProcess
+func (in *instance) supervise() {
+	defer close(in.done)
+	backoff := minBackoff
+	for {
+		var exited chan struct{}
+		if err := in.start(); err != nil {
+			in.output.printf(in.name, "启动失败: %v\n", err)
+		} else {
+			in.mutex.Lock()
+			exited = in.exited
+			in.mutex.Unlock()
+			<-exited
+		}
+
+		in.mutex.Lock()
+		stopped, planned := in.stopped, in.restart
+		in.restart = false
+		ran := time.Since(in.startedAt)
+		if !stopped && !planned {
+			in.restarts++
+		}
+		in.mutex.Unlock()
+		if stopped {
+			return
+		}
+		if planned {
+			continue
+		}
+		if exited != nil && ran > stableAfter {
+			backoff = minBackoff
+		}
+		in.output.printf(in.name, "%v后重启\n", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-in.stop:
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// current 返回当前进程和它退出时关闭的channel, 进程没有运行时返回nil
+func (in *instance) current() (*exec.Cmd, chan struct{}) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	if in.cmd == nil {
+		return nil, nil
+	}
+	select {
+	case <-in.exited:
+		return nil, nil
+	default:
+		return in.cmd, in.exited
+	}
+}
+
+// terminateAndWait 请求进程退出, 超时后强制结束
+func (in *instance) terminateAndWait(cmd *exec.Cmd, exited chan struct{}) {
+	if err := terminate(cmd.Process); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		in.output.printf(in.name, "发送退出信号失败: %v\n", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		in.output.printf(in.name, "%v内没有退出, 强制结束\n", stopTimeout)
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// stopProcess 停止实例, 不再重启
+func (in *instance) stopProcess() {
+	in.mutex.Lock()
+	if in.stopped {
+		in.mutex.Unlock()
+		<-in.done
+		return
+	}
+	in.stopped = true
+	close(in.stop)
+	started := in.started
+	in.mutex.Unlock()
+	if !started {
+		// 启动过程中被停止, supervise不会再运行, 由这里关闭done
+		close(in.done)
+		return
+	}
+	if cmd, exited := in.current(); cmd != nil {
+		in.terminateAndWait(cmd, exited)
+	}
+	<-in.done
+}
+
+// restartProcess 重启实例并等待新进程就绪, 用于滚动重启
+func (in *instance) restartProcess() error {
+	cmd, exited := in.current()
+	if cmd == nil {
+		return fmt.Errorf("%s没有在运行", in.name)
+	}
+	in.mutex.Lock()
+	if in.stopped {
+		in.mutex.Unlock()
+		return fmt.Errorf("%s已经停止", in.name)
+	}
+	in.restart = true
+	in.mutex.Unlock()
+	in.terminateAndWait(cmd, exited)
+	return in.waitReady(context.Background())
+}
+
+// waitReady 等待实例就绪: 有就绪检查URL时轮询到返回200, 否则等待startDelay. 实例停止或者ctx结束时立即返回
+func (in *instance) waitReady(ctx context.Context) error {
+	if in.ready == "" {
+		select {
+		case <-time.After(startDelay):
+			return nil
+		case <-in.stop:
+			return fmt.Errorf("%s已经停止", in.name)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		// 等新进程启动后再检查, 避免检查到正在退出的旧进程
+		if cmd, _ := in.current(); cmd != nil {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, in.ready, nil)
+			if err != nil {
+				return err
+			}
+			res, err := client.Do(req)
+			if err == nil {
+				_ = res.Body.Close()
+				if res.StatusCode == http.StatusOK {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-in.stop:
+			return fmt.Errorf("%s已经停止", in.name)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("%s在%v内没有就绪", in.name, readyTimeout)
+}
+
+// status 实例的状态, 用于/status接口
+type status struct {
+	Name     string
+	Service  string
+	Pid      int `json:",omitempty"`
+	Running  bool
+	Since    time.Time `json:",omitempty"`
+	Restarts int
+}
+
+func (in *instance) status() status {
+	st := status{Name: in.name, Service: in.service.Name}
+	cmd, _ := in.current()
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	st.Restarts = in.restarts
+	if cmd != nil {
+		st.Pid, st.Running, st.Since = cmd.Process.Pid, true, in.startedAt
+	}
+	return st
+}
+
+// output 所有子进程共用的输出, 每一行加上实例名前缀. logDir不为空时同时写入<logDir>/<实例名>.log
+type output struct {
+	w      io.Writer
+	logDir string
+	mutex  *sync.Mutex
+	files  map[string]*os.File
+}
+
+func newOutput(w io.Writer, logDir string) (*output, error) {
+	if logDir != "" {
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &output{w: w, logDir: logDir, mutex: &sync.Mutex{}, files: make(map[string]*os.File)}, nil
+}
+
+func (o *output) copy(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		o.line(name, scanner.Text())
+	}
+	// 一行太长时不再读取, 但需要读完管道, 否则子进程会阻塞在写输出上
+	_, _ = io.Copy(io.Discard, r)
+}
+
+func (o *output) printf(name, format string, args ...any) {
+	o.line(name, "[supervisor] "+fmt.Sprintf(format, args...))
+}
+
+func (o *output) line(name, text string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	text = strings.TrimRight(text, "\r\n")
+	_, _ = fmt.Fprintf(o.w, "%-12s | %s\n", name, text)
+	if o.logDir == "" {
+		return
+	}
+	f, ok := o.files[name]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(filepath.Join(o.logDir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Printf("打开输出文件失败: %v\n", err)
+			o.logDir = ""
+			return
+		}
+		o.files[name] = f
+	}
+	_, _ = fmt.Fprintf(f, "%s %s\n", time.Now().Format(time.RFC3339), text)
+}
+
+func (o *output) close() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, f := range o.files {
+		_ = f.Close()
+	}
+}
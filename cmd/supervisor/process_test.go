@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestInstance(name, ready string) *instance {
+	out, _ := newOutput(io.Discard, "")
+	return &instance{
+		service: &Service{Name: name},
+		name:    name,
+		path:    "/nonexistent/" + name,
+		ready:   ready,
+		output:  out,
+		mutex:   &sync.Mutex{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// within 在d内等待fn返回
+func within(t *testing.T, d time.Duration, what string, fn func()) {
+	t.Helper()
+	finished := make(chan struct{})
+	go func() {
+		fn()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(d):
+		t.Fatalf("%s在%v内没有返回", what, d)
+	}
+}
+
+func TestWaitReadyCancel(t *testing.T) {
+	tests := []struct {
+		name  string
+		ready string
+	}{
+		{"没有就绪检查URL", ""},
+		{"就绪检查URL不可达", "http://127.0.0.1:1/ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := newTestInstance("svc", tt.ready)
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			within(t, time.Second, "waitReady", func() {
+				if err := in.waitReady(ctx); !errors.Is(err, context.Canceled) {
+					t.Errorf("waitReady返回%v, 期望context.Canceled", err)
+				}
+			})
+		})
+	}
+}
+
+// TestStopDuringStart 启动过程中收到Ctrl-C: 还没有启动的服务不会启动, stop不会等待它们
+func TestStopDuringStart(t *testing.T) {
+	first := newTestInstance("first", "http://127.0.0.1:1/ready")
+	second := newTestInstance("second", "")
+	sup := &supervisor{
+		services: []*Service{
+			{Name: "first", instances: []*instance{first}},
+			{Name: "second", instances: []*instance{second}},
+		},
+		mutex: &sync.Mutex{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	within(t, time.Second, "start", func() { sup.start(ctx) })
+	within(t, time.Second, "stop", sup.stop)
+	if second.started {
+		t.Fatal("ctx结束后不应该再启动后面的服务")
+	}
+	// 停止之后再启动也不会运行supervise
+	second.launch()
+	if second.started {
+		t.Fatal("已经停止的实例不应该再启动")
+	}
+}
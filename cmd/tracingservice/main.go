@@ -0,0 +1,31 @@
+package main
+
+import (
+	"DistributedGo/app"
+	"flag"
+	"fmt"
+	stlog "log"
+)
+
+func main() {
+	stdin := flag.Bool("stdin", false, "允许在标准输入中输入任意内容关闭服务")
+	var opts app.ServiceOptions
+	flag.StringVar(&opts.Host, "host", "localhost", "监听的主机")
+	flag.StringVar(&opts.Port, "port", ":10003", "监听的端口, :0表示由系统分配")
+	flag.StringVar(&opts.AdvertiseAddr, "advertise", "", "注册到注册中心的地址, 为空时使用监听的主机和实际的端口")
+	flag.StringVar(&opts.Instance, "instance", "", "实例后缀, 同时启动多个实例时用来区分")
+	tlsConfig := app.TLSFlags()
+	adminConfig := app.AdminFlags()
+	flag.Parse()
+	opts.TLS, opts.Admin = tlsConfig(), adminConfig()
+
+	ctx, stop := app.ShutdownContext(*stdin)
+	defer stop()
+
+	done, err := app.RunTracingService(ctx, opts)
+	if err != nil {
+		stlog.Fatalln("启动服务失败:", err)
+	}
+	<-done.Done()
+	fmt.Println("服务[TracingService]已关闭")
+}
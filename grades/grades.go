@@ -1,6 +1,10 @@
 package grades
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
 
 type Student struct {
 	ID        int
@@ -24,6 +28,14 @@ type Students []Student
 
 var students Students
 
+// CheckStudents 业务健康检查: 学生数据已经加载
+func CheckStudents(ctx context.Context) error {
+	if len(students) == 0 {
+		return errors.New("学生数据没有加载")
+	}
+	return nil
+}
+
 func (ss Students) GetByID(id int) (*Student, error) {
 	for i, s := range ss {
 		if s.ID == id {
@@ -8,10 +8,20 @@ import (
 	"strings"
 )
 
-func RegisterHandler() {
+// logger 处理请求时输出错误使用的Logger, 默认是标准库log的默认Logger
+var logger = log.Default()
+
+// SetLogger 设置处理请求时使用的Logger, 需要在注册处理器之前调用.
+// 成绩服务把自己的日志发往日志服务时使用它, 不改变进程全局的标准库log.
+func SetLogger(l *log.Logger) {
+	logger = l
+}
+
+// RegisterHandler 在服务自己的ServeMux上注册处理器
+func RegisterHandler(mux *http.ServeMux) {
 	sh := &studentHandler{}
-	http.Handle("/students", sh)  // 请求集合数据
-	http.Handle("/students/", sh) // 请求单个数据的操作
+	mux.Handle("/students", sh)  // 请求集合数据
+	mux.Handle("/students/", sh) // 请求单个数据的操作
 }
 
 type studentHandler struct{}
@@ -53,7 +63,7 @@ func handlePostStudentGrades(w http.ResponseWriter, r *http.Request, s string) {
 	id, err := strconv.Atoi(s)
 	if err != nil {
 		http.Error(w, "Invalid student ID", http.StatusBadRequest)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	student, err := students.GetByID(id)
@@ -66,7 +76,7 @@ func handlePostStudentGrades(w http.ResponseWriter, r *http.Request, s string) {
 	err = decoder.Decode(&grade)
 	if err != nil {
 		http.Error(w, "Invalid grade data", http.StatusBadRequest)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	student.Grades = append(student.Grades, grade)
@@ -75,13 +85,13 @@ func handlePostStudentGrades(w http.ResponseWriter, r *http.Request, s string) {
 	sj, err := json.Marshal(student)
 	if err != nil {
 		http.Error(w, "Failed to marshal student", http.StatusInternalServerError)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(sj)
 	if err != nil {
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 
@@ -91,25 +101,25 @@ func handleGetStudentByID(w http.ResponseWriter, r *http.Request, s string) {
 	id, err := strconv.Atoi(s)
 	if err != nil {
 		http.Error(w, "Invalid student ID", http.StatusBadRequest)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	student, err := students.GetByID(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	sj, err := json.Marshal(student)
 	if err != nil {
 		http.Error(w, "Failed to marshal student", http.StatusInternalServerError)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(sj)
 	if err != nil {
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 }
@@ -118,13 +128,13 @@ func handleGetAllStudents(w http.ResponseWriter, r *http.Request) {
 	sj, err := json.Marshal(students)
 	if err != nil {
 		http.Error(w, "Failed to marshal students", http.StatusInternalServerError)
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(sj)
 	if err != nil {
-		log.Println(err)
+		logger.Println(err)
 		return
 	}
 
@@ -2,42 +2,205 @@ package log
 
 import (
 	"DistributedGo/registry"
+	"DistributedGo/tracing"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	stlog "log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // 提供一个方法供客户端使用
+// serviceUrl 可以直接使用服务名作为主机名, 如"http://LogService/log", 每次发送日志时通过服务发现选择日志服务的实例.
+// SetClientLogger使用默认客户端; 服务有自己的registry.Client时使用SetClientLoggerWithClient, client为nil时同样使用默认客户端.
+// 客户端发送JSON格式的Record, 服务名、实例ID、时间都作为记录的字段, 所以标准库log不再加前缀.
 
 func SetClientLogger(serviceUrl string, clientService registry.ServiceName) {
-	//return stlog.New(&clientLogger{url: serviceUrl}, fmt.Sprintf("[%v] - ", clientService), 0)
-	stlog.SetPrefix(fmt.Sprintf("[%v] - ", clientService))
-	stlog.SetFlags(0)
-	stlog.SetOutput(&clientLogger{url: serviceUrl})
+	SetClientLoggerWithClient(nil, serviceUrl, clientService)
 }
 
-type clientLogger struct {
-	url string
+// SetClientLoggerWithClient 与SetClientLogger相同, 但通过服务自己的client发现日志服务
+func SetClientLoggerWithClient(client *registry.Client, serviceUrl string, clientService registry.ServiceName) {
+	RedirectLogger(stlog.Default(), client, serviceUrl, clientService)
 }
 
-func (c *clientLogger) Write(data []byte) (n int, err error) {
+// RedirectLogger 把l的输出改为发往日志服务. 服务使用自己的Logger时用它, 不影响同一个进程中其他组件的标准库log.
+func RedirectLogger(l *stlog.Logger, client *registry.Client, serviceUrl string, clientService registry.ServiceName) {
+	l.SetPrefix("")
+	l.SetFlags(0)
+	l.SetOutput(&clientLogger{sender: NewSender(client, serviceUrl, clientService)})
+}
+
+// NewClientLogger 返回一个把日志发往日志服务的Logger, 参数与SetClientLoggerWithClient相同, 但不改变标准库log的默认输出.
+func NewClientLogger(client *registry.Client, serviceUrl string, clientService registry.ServiceName) *stlog.Logger {
+	return stlog.New(&clientLogger{sender: NewSender(client, serviceUrl, clientService)}, "", 0)
+}
+
+// Sender 把结构化的日志记录发往日志服务, 需要级别和附加字段时直接使用它:
+//
+//	sender.Log(ctx, log.LevelWarn, "成绩不存在", map[string]any{"student": id})
+//
+// Log和Send同步发送并返回结果; Enqueue把记录放进有上限的队列后立即返回, 由后台goroutine发送,
+// 队列满时丢弃. 标准库log通过clientLogger输出时使用Enqueue, 日志服务变慢或不可用不会拖慢处理请求的goroutine.
+type Sender struct {
+	url        string
+	service    registry.ServiceName
+	client     *registry.Client
+	httpClient *http.Client // 发送日志的客户端, 通过服务发现解析服务名
+	queue      []queuedRecord
+	sending    bool // 是否有goroutine在发送队列中的记录, 队列清空后goroutine退出
+	mutex      *sync.Mutex
+}
+
+const (
+	sendTimeout     = 2 * time.Second
+	senderQueueSize = 1024
+)
+
+// queuedRecord 等待发送的记录和它的ctx
+type queuedRecord struct {
+	ctx    context.Context
+	record Record
+}
+
+func NewSender(client *registry.Client, serviceUrl string, clientService registry.ServiceName) *Sender {
+	if client == nil {
+		client = registry.DefaultClient()
+	}
+	httpClient := client.HTTPClient()
+	// 日志服务没有响应时, 发送日志的请求不会一直挂起
+	httpClient.Timeout = sendTimeout
+	return &Sender{url: serviceUrl, service: clientService, client: client, httpClient: httpClient, mutex: &sync.Mutex{}}
+}
+
+// Enqueue 异步发送一条记录, 队列满时丢弃并返回false. ctx的取消不影响发送, 请求结束后记录仍然会发出,
+// 其中的追踪上下文和请求ID照常写入记录.
+func (s *Sender) Enqueue(ctx context.Context, record Record) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.queue) >= senderQueueSize {
+		return false
+	}
+	s.queue = append(s.queue, queuedRecord{ctx: context.WithoutCancel(ctx), record: record})
+	if !s.sending {
+		s.sending = true
+		go s.drain()
+	}
+	return true
+}
+
+// drain 依次发送队列中的记录, 队列为空时退出. 发送失败的记录直接丢弃: 这里不能再通过标准库log输出错误,
+// 它的输出可能就是发往日志服务的clientLogger.
+func (s *Sender) drain() {
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.sending = false
+			s.queue = nil
+			s.mutex.Unlock()
+			return
+		}
+		q := s.queue[0]
+		s.queue[0] = queuedRecord{}
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+		_ = s.Send(q.ctx, q.record)
+	}
+}
+
+// Log 发送一条日志. ctx中的追踪上下文和请求ID会写入记录, 发送日志的请求也在同一个trace中.
+func (s *Sender) Log(ctx context.Context, level Level, msg string, fields map[string]any) error {
+	return s.Send(ctx, Record{Level: level, Message: msg, Fields: fields})
+}
+
+// Send 发送一条记录, 没有填写的时间、服务名、实例ID、trace ID和请求ID自动补全
+func (s *Sender) Send(ctx context.Context, record Record) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if record.Level == "" {
+		record.Level = LevelInfo
+	}
+	if record.Service == "" {
+		record.Service = string(s.service)
+	}
+	if record.Instance == "" {
+		record.Instance = s.client.InstanceID()
+	}
+	if sc, ok := tracing.FromContext(ctx); ok && record.TraceID == "" {
+		record.TraceID = sc.TraceID
+	}
+	if record.RequestID == "" {
+		record.RequestID = registry.RequestIDFrom(ctx)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
 	// 将日志发送到远程日志服务
-	b := bytes.NewBuffer(data)
-	res, err := http.Post(c.url, "text/plain", b)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
 	if err != nil {
-		return 0, err
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-
-		}
+		_ = Body.Close()
 	}(res.Body)
 	if res.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("failed to send log: %d, message: %s", res.StatusCode, string(data))
+		return fmt.Errorf("failed to send log: %d, message: %s", res.StatusCode, record.Message)
 	}
+	return nil
+}
+
+var errQueueFull = errors.New("日志发送队列已满, 丢弃日志")
+
+// clientLogger 让标准库log通过Sender发送日志, 每次Write作为一条info级别的记录放进Sender的队列, 不等待发送完成.
+// 写入的内容是JSON对象时(例如中间件的访问日志)作为附加字段, 消息使用其中的type.
+type clientLogger struct {
+	sender *Sender
+	ctx    context.Context // 发送日志的请求使用的ctx, 为nil时使用context.Background
+}
 
+// WithContext 返回使用ctx发送日志的Writer. 处理请求时记录的日志带上请求的追踪上下文和请求ID,
+// 日志服务收到的请求和原来的请求在同一个trace中.
+func (c *clientLogger) WithContext(ctx context.Context) io.Writer {
+	cp := *c
+	cp.ctx = ctx
+	return &cp
+}
+
+func (c *clientLogger) Write(data []byte) (n int, err error) {
+	record := Record{Level: LevelInfo, Message: strings.TrimRight(string(data), "\n")}
+	if strings.HasPrefix(record.Message, "{") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(record.Message), &fields); err == nil {
+			record.Fields = fields
+			if t, ok := fields["type"].(string); ok && t != "" {
+				record.Message = t
+			}
+		}
+	}
+	if !c.sender.Enqueue(c.ctx, record) {
+		return 0, errQueueFull
+	}
 	return len(data), nil
 }
@@ -0,0 +1,69 @@
+package log
+
+import (
+	"DistributedGo/registry"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestClientLoggerAsync 日志服务没有响应时, 输出日志不等待发送完成; 请求结束后记录仍然带着请求ID发出
+func TestClientLoggerAsync(t *testing.T) {
+	release := make(chan struct{})
+	records := make(chan Record, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var record Record
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		records <- record
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	logger := NewClientLogger(registry.NewClient(registry.ClientOptions{}), srv.URL+"/log", "Svc")
+	ctx, cancel := context.WithCancel(registry.WithRequestID(context.Background(), "req-1"))
+	w := logger.Writer().(*clientLogger).WithContext(ctx)
+	start := time.Now()
+	for range 3 {
+		if _, err := w.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("输出日志等待了%v", d)
+	}
+	cancel()
+	release <- struct{}{}
+	select {
+	case record := <-records:
+		if record.Message != "hello" || record.RequestID != "req-1" || record.Service != "Svc" {
+			t.Fatalf("记录不正确: %+v", record)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ctx取消后记录没有发出")
+	}
+}
+
+func TestSenderQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := NewSender(registry.NewClient(registry.ClientOptions{}), srv.URL+"/log", "Svc")
+	dropped := 0
+	for range senderQueueSize + 10 {
+		if !s.Enqueue(context.Background(), Record{Message: "hello"}) {
+			dropped++
+		}
+	}
+	// 后台goroutine最多取走一条正在发送
+	if dropped < 9 {
+		t.Fatalf("队列满时只丢弃了%d条", dropped)
+	}
+}
@@ -0,0 +1,378 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GET /log 查询日志文件中的记录, 新的在前. 查询参数:
+//
+//	service     服务名
+//	instance    实例ID
+//	level       最低级别, 例如warn返回warn和error
+//	since/until 时间范围, RFC3339格式的时间, 或者"15m"这样相对于现在的时间
+//	q           消息中包含的文本, 不区分大小写
+//	regex       消息匹配的正则表达式
+//	trace_id    trace ID
+//	request_id  请求ID
+//	limit       每页的数量, 默认100, 最多1000
+//	cursor      翻页的位置, 使用上一页返回的NextCursor
+//
+// 结构化之前写入的纯文本行按旧格式解析, 无法解析的行跳过. 当前文件查完后继续查询轮转后的文件.
+// 游标记录的是上一页停在哪个文件的哪个字节, 不是跳过的记录数, 翻页期间写入新的记录或者文件被轮转时不会重复或者漏掉记录.
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+	sinceSlack        = time.Minute
+)
+
+// Query 日志查询的条件
+type Query struct {
+	Service   string
+	Instance  string
+	Level     Level
+	Since     time.Time
+	Until     time.Time
+	Contains  string
+	Regexp    *regexp.Regexp
+	TraceID   string
+	RequestID string
+	Limit     int
+	Cursor    string // 上一页返回的NextCursor, 为空时从最新的记录开始
+}
+
+// QueryResult 查询结果. 还有更多记录时NextCursor是下一页的cursor, 否则为空.
+type QueryResult struct {
+	Records    []Record
+	NextCursor string `json:",omitempty"`
+}
+
+// cursor 翻页的位置, 对调用方不透明: 下一页从Segment文件中Pos字节之前的一行开始向前读取.
+// Segment是轮转后的文件名(不含目录和.gz, 压缩前后读出的内容相同), 为空表示当前文件.
+// 当前文件可能在两次查询之间被轮转, 所以同时记下查询时最新的轮转文件Newest:
+// 之后最新的轮转文件变了, 原来的当前文件就是Newest之后最早轮转的那个文件.
+type cursor struct {
+	Segment string `json:"s,omitempty"`
+	Pos     int64  `json:"p"`
+	Newest  string `json:"n,omitempty"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(v string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Pos <= 0 {
+		return c, errors.New("cursor无效")
+	}
+	return c, nil
+}
+
+var levelRank = map[Level]int{LevelDebug: 0, LevelInfo: 1, LevelWarn: 2, LevelError: 3}
+
+// parseQuery 解析查询参数
+func parseQuery(values url.Values, now time.Time) (Query, error) {
+	q := Query{
+		Service:   values.Get("service"),
+		Instance:  values.Get("instance"),
+		Level:     Level(values.Get("level")),
+		Contains:  strings.ToLower(values.Get("q")),
+		TraceID:   values.Get("trace_id"),
+		RequestID: values.Get("request_id"),
+		Limit:     defaultQueryLimit,
+	}
+	if _, ok := levelRank[q.Level]; q.Level != "" && !ok {
+		return q, fmt.Errorf("未知的日志级别: %s", q.Level)
+	}
+	var err error
+	if q.Since, err = parseTime(values.Get("since"), now); err != nil {
+		return q, fmt.Errorf("since无效: %w", err)
+	}
+	if q.Until, err = parseTime(values.Get("until"), now); err != nil {
+		return q, fmt.Errorf("until无效: %w", err)
+	}
+	if expr := values.Get("regex"); expr != "" {
+		if q.Regexp, err = regexp.Compile(expr); err != nil {
+			return q, fmt.Errorf("regex无效: %w", err)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, errors.New("limit必须是正整数")
+		}
+		q.Limit = min(q.Limit, maxQueryLimit)
+	}
+	if q.Cursor = values.Get("cursor"); q.Cursor != "" {
+		if _, err := decodeCursor(q.Cursor); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// parseTime 解析RFC3339时间, 或者相对于now的时长
+func parseTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// match 判断记录是否满足查询条件
+func (q Query) match(r Record) bool {
+	switch {
+	case q.Service != "" && r.Service != q.Service,
+		q.Instance != "" && r.Instance != q.Instance,
+		q.TraceID != "" && r.TraceID != q.TraceID,
+		q.RequestID != "" && r.RequestID != q.RequestID,
+		q.Level != "" && levelRank[r.Level] < levelRank[q.Level],
+		!q.Since.IsZero() && r.Time.Before(q.Since),
+		!q.Until.IsZero() && r.Time.After(q.Until),
+		q.Contains != "" && !strings.Contains(strings.ToLower(r.Message), q.Contains),
+		q.Regexp != nil && !q.Regexp.MatchString(r.Message):
+		return false
+	}
+	return true
+}
+
+// search 从新到旧扫描日志文件和轮转后的文件, 取得一页匹配的记录. 多取一条用来判断是否还有下一页,
+// 下一页的游标指向多取的这一条.
+func search(file string, q Query) (QueryResult, error) {
+	result := QueryResult{Records: make([]Record, 0, q.Limit)}
+	rotated := rotatedFiles(file)
+	files := append([]string{file}, rotated...)
+	first, end := 0, int64(-1)
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return result, err
+		}
+		first, end = resume(file, files, c)
+	}
+	newest := ""
+	if len(rotated) > 0 {
+		newest = segmentOf(rotated[0])
+	}
+	segment := ""
+	stopped := false
+	fn := func(line []byte, lineEnd int64) bool {
+		r, ok := parseLine(string(line))
+		if !ok {
+			return true
+		}
+		// 记录基本按时间顺序写入, 遇到比since早很多的记录时, 更早的行中不会再有匹配的记录.
+		// 记录的时间由客户端填写, 不同服务之间可能略有偏差, 所以留一些余量
+		if !q.Since.IsZero() && r.Time.Before(q.Since.Add(-sinceSlack)) {
+			stopped = true
+			return false
+		}
+		if !q.match(r) {
+			return true
+		}
+		if len(result.Records) == q.Limit {
+			next := cursor{Segment: segment, Pos: lineEnd}
+			if segment == "" {
+				next.Newest = newest
+			}
+			result.NextCursor = next.encode()
+			stopped = true
+			return false
+		}
+		result.Records = append(result.Records, r)
+		return true
+	}
+	for i := first; i < len(files); i++ {
+		name := files[i]
+		if i > 0 {
+			segment = segmentOf(name)
+		}
+		// 只有游标所在的文件从中间开始读取
+		limit := int64(-1)
+		if i == first {
+			limit = end
+		}
+		err := scanFileBackward(name, limit, fn)
+		if errors.Is(err, os.ErrNotExist) && !strings.HasSuffix(name, ".gz") {
+			// 查询期间文件可能已经被压缩
+			err = scanFileBackward(name+".gz", limit, fn)
+		}
+		// 文件可能已经按保留策略删除
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, err
+		}
+		if stopped {
+			break
+		}
+	}
+	return result, nil
+}
+
+// resume 找到游标所在的文件在files(当前文件和轮转后的文件, 新的在前)中的位置, 返回位置和从哪个字节之前开始读取,
+// -1表示读取整个文件. 游标所在的文件已经被清理时从更早的文件开始.
+func resume(path string, files []string, c cursor) (int, int64) {
+	segment := c.Segment
+	if segment == "" {
+		// 上一页之后当前文件被轮转了: 它是Newest之后最早轮转的文件
+		for i := len(files) - 1; i >= 1; i-- {
+			if c.Newest == "" || compareRotated(path, files[i], c.Newest) < 0 {
+				segment = segmentOf(files[i])
+				break
+			}
+		}
+		if segment == "" {
+			return 0, c.Pos
+		}
+	}
+	for i := 1; i < len(files); i++ {
+		if segmentOf(files[i]) == segment {
+			return i, c.Pos
+		}
+		if compareRotated(path, files[i], segment) > 0 {
+			return i, -1
+		}
+	}
+	return len(files), -1
+}
+
+// segmentOf 游标中记录的文件名, 压缩前后相同
+func segmentOf(name string) string {
+	return strings.TrimSuffix(filepath.Base(name), ".gz")
+}
+
+// scanFileBackward 从新到旧读取一个日志文件, 压缩的文件需要先解压. end和fn的参数与scanBackward相同
+func scanFileBackward(name string, end int64, fn func(line []byte, lineEnd int64) bool) error {
+	if !strings.HasSuffix(name, ".gz") {
+		return scanBackward(name, end, fn)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	// gzip不能从末尾读取. MaxSize为0(只按时间轮转)时单个文件没有上限, 所以不在内存中解压,
+	// 而是流式解压到临时文件, 再和未压缩的文件一样按块从末尾读取, 内存占用与文件大小无关.
+	tmp, err := os.CreateTemp("", "distributed_go-query-*.log")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := io.Copy(tmp, zr); err != nil {
+		return err
+	}
+	return scanBackward(tmp.Name(), end, fn)
+}
+
+// scanBackward 从文件末尾开始逐行向前读取, fn返回false时停止. 按块读取, 不需要把整个文件读进内存.
+// end不小于0时只读取前end个字节, 即从这个位置之前的一行开始. fn同时得到这一行结束的位置(不含换行符), 用作翻页的游标.
+func scanBackward(file string, end int64, fn func(line []byte, lineEnd int64) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	const chunkSize = 64 * 1024
+	pos := info.Size()
+	if end >= 0 && end < pos {
+		pos = end
+	}
+	var rest []byte // 上一块开头不完整的一行
+	buf := make([]byte, chunkSize)
+	for pos > 0 {
+		n := int64(chunkSize)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		if _, err := f.ReadAt(buf[:n], pos); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		data := append(append([]byte(nil), buf[:n]...), rest...)
+		lines := bytes.Split(data, []byte("\n"))
+		// 第一段可能是不完整的一行, 和前一块一起处理
+		rest = lines[0]
+		lineEnd := pos + int64(len(data))
+		for i := len(lines) - 1; i >= 1; i-- {
+			if len(lines[i]) > 0 && !fn(lines[i], lineEnd) {
+				return nil
+			}
+			lineEnd -= int64(len(lines[i])) + 1
+		}
+	}
+	if len(rest) > 0 {
+		fn(rest, int64(len(rest)))
+	}
+	return nil
+}
+
+// parseLine 解析日志文件中的一行. 结构化之前写入的行形如"[Go]- 2006/01/02 15:04:05 [GradingService] - 消息", 按旧格式解析.
+func parseLine(line string) (Record, bool) {
+	if strings.HasPrefix(line, "{") {
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err == nil {
+			return r, true
+		}
+	}
+	const legacyPrefix = "[Go]- "
+	const layout = "2006/01/02 15:04:05"
+	if !strings.HasPrefix(line, legacyPrefix) || len(line) < len(legacyPrefix)+len(layout)+1 {
+		return Record{}, false
+	}
+	rest := line[len(legacyPrefix):]
+	t, err := time.ParseInLocation(layout, rest[:len(layout)], time.Local)
+	if err != nil {
+		return Record{}, false
+	}
+	r := parseLegacy(rest[len(layout)+1:])
+	r.Time = t
+	return r, true
+}
+
+// serveQuery 处理GET /log
+func serveQuery(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := search(destination, q)
+	if err != nil {
+		http.Error(w, "Failed to read log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
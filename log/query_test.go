@@ -0,0 +1,309 @@
+package log
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		wantErr bool
+		check   func(Query) bool
+	}{
+		{"", false, func(q Query) bool { return q.Limit == defaultQueryLimit && q.Cursor == "" }},
+		{"service=GradingService&level=warn", false, func(q Query) bool { return q.Service == "GradingService" && q.Level == LevelWarn }},
+		{"since=15m", false, func(q Query) bool { return q.Since.Equal(now.Add(-15 * time.Minute)) }},
+		{"until=2026-10-19T10:00:00Z", false, func(q Query) bool { return q.Until.Equal(now.Add(-2 * time.Hour)) }},
+		{"q=TimeOut", false, func(q Query) bool { return q.Contains == "timeout" }},
+		{"limit=5000", false, func(q Query) bool { return q.Limit == maxQueryLimit }},
+		{"cursor=" + cursor{Pos: 20}.encode(), false, func(q Query) bool { return q.Cursor == cursor{Pos: 20}.encode() }},
+		{"regex=^a.*b$", false, func(q Query) bool { return q.Regexp != nil && q.Regexp.MatchString("axb") }},
+		{"level=fatal", true, nil},
+		{"since=yesterday", true, nil},
+		{"regex=(", true, nil},
+		{"limit=0", true, nil},
+		{"limit=abc", true, nil},
+		{"cursor=20", true, nil},
+		{"cursor=" + cursor{Segment: "app.log"}.encode(), true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			q, err := parseQuery(values, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQuery(%s) 错误: %v", tt.query, err)
+			}
+			if err == nil && !tt.check(q) {
+				t.Fatalf("parseQuery(%s) = %+v", tt.query, q)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Now()
+	r := Record{Time: now, Level: LevelWarn, Service: "GradingService", Instance: "i1", Message: "Request Timeout", TraceID: "t1"}
+	tests := []struct {
+		name string
+		q    Query
+		want bool
+	}{
+		{"空条件", Query{}, true},
+		{"服务名", Query{Service: "GradingService"}, true},
+		{"其他服务", Query{Service: "LogService"}, false},
+		{"最低级别info", Query{Level: LevelInfo}, true},
+		{"最低级别error", Query{Level: LevelError}, false},
+		{"时间范围内", Query{Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}, true},
+		{"早于since", Query{Since: now.Add(time.Minute)}, false},
+		{"子串不区分大小写", Query{Contains: "timeout"}, true},
+		{"trace ID", Query{TraceID: "t2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.match(r); got != tt.want {
+				t.Fatalf("match = %v, 期望%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		service string
+		msg     string
+	}{
+		{`{"time":"2026-10-19T12:00:00Z","level":"info","service":"Svc","msg":"hello"}`, true, "Svc", "hello"},
+		{"[Go]- 2026/10/19 12:00:00 [GradingService] - 旧格式", true, "GradingService", "旧格式"},
+		{"[Go]- 2026/10/19 12:00:00 没有服务名", true, "", "没有服务名"},
+		{"[Go]- 不是时间", false, "", ""},
+		{"随便一行", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			r, ok := parseLine(tt.line)
+			if ok != tt.ok || r.Service != tt.service || r.Message != tt.msg {
+				t.Fatalf("parseLine = %+v, %v", r, ok)
+			}
+		})
+	}
+}
+
+// TestScanBackward 行跨越读取块的边界时也要完整地逆序读出
+func TestScanBackward(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.log")
+	var lines []string
+	var b strings.Builder
+	for i := range 3000 {
+		line := fmt.Sprintf("%04d %s", i, strings.Repeat("x", i%97))
+		lines = append(lines, line)
+		b.WriteString(line + "\n")
+	}
+	data := b.String()
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := scanBackward(file, -1, func(line []byte, lineEnd int64) bool {
+		// 游标位置之前正好是这一行
+		if !strings.HasSuffix(data[:lineEnd], string(line)) {
+			t.Fatalf("%q结束的位置%d不对", line, lineEnd)
+		}
+		got = append(got, string(line))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(lines) {
+		t.Fatalf("读出%d行, 期望%d行", len(got), len(lines))
+	}
+	for i, line := range got {
+		if want := lines[len(lines)-1-i]; line != want {
+			t.Fatalf("第%d行为%q, 期望%q", i, line, want)
+		}
+	}
+
+	// 从中间一行结束的位置开始, 先读到这一行
+	end := int64(strings.Index(data, lines[1500]+"\n") + len(lines[1500]))
+	var first string
+	if err := scanBackward(file, end, func(line []byte, _ int64) bool {
+		first = string(line)
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if first != lines[1500] {
+		t.Fatalf("从位置%d开始读到%q, 期望%q", end, first, lines[1500])
+	}
+}
+
+func writeRecords(t *testing.T, name string, records ...Record) {
+	t.Helper()
+	var b strings.Builder
+	for _, r := range records {
+		data, _ := json.Marshal(r)
+		b.Write(data)
+		b.WriteString("\n")
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		if err := os.WriteFile(name, []byte(b.String()), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	_, _ = zw.Write([]byte(b.String()))
+	_ = zw.Close()
+	_ = f.Close()
+}
+
+func messages(rs []Record) string {
+	var ms []string
+	for _, r := range rs {
+		ms = append(ms, r.Message)
+	}
+	return strings.Join(ms, ",")
+}
+
+// pages 按游标依次取出所有页
+func pages(t *testing.T, file string, q Query) []string {
+	t.Helper()
+	var got []string
+	for {
+		result, err := search(file, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, messages(result.Records))
+		if result.NextCursor == "" {
+			return got
+		}
+		q.Cursor = result.NextCursor
+	}
+}
+
+// TestSearchRotated 查询当前文件和轮转后的文件(包括压缩的文件), 新的在前, 支持翻页
+func TestSearchRotated(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	base := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	record := func(i int) Record {
+		return Record{Time: base.Add(time.Duration(i) * time.Minute), Level: LevelInfo, Service: "Svc", Message: fmt.Sprint("m", i)}
+	}
+	writeRecords(t, filepath.Join(dir, "app-20261019T120200.000.log.gz"), record(0), record(1))
+	writeRecords(t, filepath.Join(dir, "app-20261019T120400.000.log"), record(2), record(3))
+	writeRecords(t, file, record(4), record(5))
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"全部", Query{Limit: 10}, []string{"m5,m4,m3,m2,m1,m0"}},
+		{"翻页", Query{Limit: 3}, []string{"m5,m4,m3", "m2,m1,m0"}},
+		{"每页一条", Query{Limit: 1}, []string{"m5", "m4", "m3", "m2", "m1", "m0"}},
+		{"过滤后翻页", Query{Limit: 2, Regexp: regexp.MustCompile("[0235]")}, []string{"m5,m3", "m2,m0"}},
+		{"since之前的文件不再读取", Query{Limit: 10, Since: base.Add(3 * time.Minute)}, []string{"m5,m4,m3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pages(t, file, tt.q); !slices.Equal(got, tt.want) {
+				t.Fatalf("得到%q, 期望%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSearchCursor 翻页期间写入新的记录、当前文件被轮转和压缩, 下一页从上一页停下的地方继续, 不重复也不遗漏
+func TestSearchCursor(t *testing.T) {
+	base := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	record := func(i int) Record {
+		return Record{Time: base.Add(time.Duration(i) * time.Minute), Level: LevelInfo, Service: "Svc", Message: fmt.Sprint("m", i)}
+	}
+	appendRecords := func(t *testing.T, name string, records ...Record) {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		for _, r := range records {
+			data, _ := json.Marshal(r)
+			_, _ = f.Write(append(data, '\n'))
+		}
+	}
+	tests := []struct {
+		name    string
+		rotated []string // 已经轮转的文件
+		// change 在两页之间修改日志文件
+		change func(t *testing.T, dir, file string)
+		want   string // 第二页
+	}{
+		{"写入新的记录", nil, func(t *testing.T, _, file string) {
+			appendRecords(t, file, record(10), record(11))
+		}, "m3,m2"},
+		{"当前文件被轮转", nil, func(t *testing.T, dir, file string) {
+			if err := os.Rename(file, filepath.Join(dir, "app-20261019T121000.000.log")); err != nil {
+				t.Fatal(err)
+			}
+			appendRecords(t, file, record(10))
+		}, "m3,m2"},
+		{"当前文件被轮转两次并压缩", []string{"app-20261019T115000.000.log"}, func(t *testing.T, dir, file string) {
+			rotated := filepath.Join(dir, "app-20261019T121000.000.log")
+			if err := os.Rename(file, rotated); err != nil {
+				t.Fatal(err)
+			}
+			if err := compress(rotated); err != nil {
+				t.Fatal(err)
+			}
+			writeRecords(t, filepath.Join(dir, "app-20261019T122000.000.log"), record(10))
+			appendRecords(t, file, record(11))
+		}, "m3,m2"},
+		{"游标所在的文件被删除", []string{"app-20261019T115000.000.log"}, func(t *testing.T, _, file string) {
+			// 第一页停在当前文件中, 删除后从更早的文件继续
+			if err := os.Remove(file); err != nil {
+				t.Fatal(err)
+			}
+		}, "m1,m0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "app.log")
+			for _, name := range tt.rotated {
+				writeRecords(t, filepath.Join(dir, name), record(0), record(1))
+			}
+			appendRecords(t, file, record(2), record(3), record(4), record(5))
+			first, err := search(file, Query{Limit: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messages(first.Records); got != "m5,m4" || first.NextCursor == "" {
+				t.Fatalf("第一页得到%s, 游标%q", got, first.NextCursor)
+			}
+			tt.change(t, dir, file)
+			second, err := search(file, Query{Limit: 2, Cursor: first.NextCursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messages(second.Records); got != tt.want {
+				t.Fatalf("第二页得到%s, 期望%s", got, tt.want)
+			}
+		})
+	}
+}
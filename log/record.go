@@ -0,0 +1,58 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Level 日志级别
+type Level string
+
+const (
+	LevelDebug Level = "debug"
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+// Record 结构化的日志记录. 客户端以JSON发送给日志服务, 日志服务每条记录在文件中写一行JSON.
+type Record struct {
+	Time      time.Time      `json:"time"`
+	Level     Level          `json:"level"`
+	Service   string         `json:"service,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	Message   string         `json:"msg"`
+	Fields    map[string]any `json:"fields,omitempty"` // 任意的附加字段, 例如学生ID
+	TraceID   string         `json:"trace_id,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// validate 检查并补全记录: 消息不能为空, 没有时间和级别时使用当前时间和info
+func (r *Record) validate() error {
+	if strings.TrimSpace(r.Message) == "" {
+		return errors.New("日志消息为空")
+	}
+	switch r.Level {
+	case "":
+		r.Level = LevelInfo
+	case LevelDebug, LevelInfo, LevelWarn, LevelError:
+	default:
+		return errors.New("未知的日志级别: " + string(r.Level))
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	return nil
+}
+
+// parseLegacy 把旧格式的纯文本日志转换为Record. 旧客户端通过标准库log发送, 内容形如"[GradingService] - 消息".
+func parseLegacy(text string) Record {
+	r := Record{Time: time.Now(), Level: LevelInfo, Message: strings.TrimRight(text, "\r\n")}
+	if strings.HasPrefix(r.Message, "[") {
+		if end := strings.Index(r.Message, "] - "); end > 0 {
+			r.Service, r.Message = r.Message[1:end], r.Message[end+len("] - "):]
+		}
+	}
+	return r
+}
@@ -0,0 +1,394 @@
+package log
+
+import (
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志文件的轮转. 当前文件超过MaxSize或者进入新的时间周期时, 改名为带时间戳的文件, 之后写入新的文件:
+//
+//	distributed_go.log                             当前文件
+//	distributed_go-20261019T144500.123.log.gz      轮转后的文件, 时间戳是轮转的时间(UTC)
+//
+// 文件名在轮转后不再变化, 按文件名排序就是时间顺序. 轮转后的文件在后台压缩, 压缩完成后按数量和时间清理旧文件.
+// 按时间轮转时每个周期结束有一个定时器, 这段时间没有新的写入也会按时轮转, 不用等到下一次写入.
+
+// RotateConfig 日志轮转的配置, 零值表示不轮转
+type RotateConfig struct {
+	MaxSize    int64         // 当前文件的最大字节数, 为0时不按大小轮转
+	Interval   time.Duration // 按时间周期轮转, 例如24h表示每天(UTC)一个文件, 为0时不按时间轮转
+	Compress   bool          // 用gzip压缩轮转后的文件
+	MaxBackups int           // 最多保留的轮转文件数量, 为0时不限制
+	MaxAge     time.Duration // 轮转文件的最长保留时间, 为0时不限制
+}
+
+// DefaultRotateConfig 日志服务命令默认的轮转配置
+var DefaultRotateConfig = RotateConfig{
+	MaxSize:    10 << 20,
+	Interval:   24 * time.Hour,
+	Compress:   true,
+	MaxBackups: 10,
+	MaxAge:     7 * 24 * time.Hour,
+}
+
+const rotatedTimeLayout = "20060102T150405.000"
+
+// rotatingFile 实现io.Writer, 写入和轮转使用同一个锁, 轮转时不会有写入进行到一半
+type rotatingFile struct {
+	path   string
+	config RotateConfig
+	mutex  *sync.Mutex
+	file   *os.File
+	size   int64
+	period time.Time // 当前文件所在的时间周期
+	now    func() time.Time
+	timer  *time.Timer // 当前周期结束时轮转
+	closed bool
+	// cleanup 串行执行压缩和清理, 同一时间只有一个后台任务在处理轮转后的文件, done在它退出后关闭
+	cleanup chan struct{}
+	done    chan struct{}
+}
+
+func newRotatingFile(path string, config RotateConfig) *rotatingFile {
+	rf := &rotatingFile{path: path, config: config, mutex: &sync.Mutex{}, now: time.Now, cleanup: make(chan struct{}, 1)}
+	rf.start()
+	return rf
+}
+
+// start 启动后台的压缩和清理
+func (rf *rotatingFile) start() {
+	rf.done = make(chan struct{})
+	go rf.cleanupLoop()
+	// 启动时处理上次没有压缩或清理完的文件
+	rf.scheduleCleanup()
+}
+
+func (rf *rotatingFile) Write(data []byte) (int, error) {
+	n, err, rotateErr := rf.write(data)
+	if rotateErr != nil {
+		// 释放锁之后再输出
+		logError("日志轮转失败:", rotateErr)
+	}
+	return n, err
+}
+
+// write 写入当前文件, 需要时先轮转. 轮转失败时继续写入当前文件, 不丢失日志, 轮转的错误由调用方在释放锁之后输出
+func (rf *rotatingFile) write(data []byte) (n int, err, rotateErr error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if rf.closed {
+		return 0, os.ErrClosed, nil
+	}
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err, nil
+		}
+	}
+	if rf.shouldRotate(int64(len(data))) {
+		if rotateErr = rf.rotate(); rotateErr != nil && rf.file == nil {
+			return 0, rotateErr, rotateErr
+		}
+	}
+	n, err = rf.file.Write(data)
+	rf.size += int64(n)
+	return n, err, rotateErr
+}
+
+// logError 轮转、压缩和清理的错误直接输出到标准错误. 不能使用标准库log: 同一个进程中它可能被设置为发往日志服务,
+// 日志服务写入时又会进入rotatingFile.
+func logError(args ...any) {
+	_, _ = fmt.Fprintln(os.Stderr, args...)
+}
+
+// open 打开当前文件, 已有的文件继续追加. 调用方需要持有锁.
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600) // 0600表示文件权限，拥有者可读写
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.file, rf.size = f, info.Size()
+	start := rf.now()
+	if info.Size() > 0 {
+		// 已有内容的文件属于最后一次写入时的周期, 重启后跨过周期时也会轮转
+		start = info.ModTime()
+	}
+	rf.period = rf.truncate(start)
+	rf.schedule()
+	return nil
+}
+
+// schedule 安排在当前周期结束时轮转, 不按时间轮转时什么也不做. 调用方需要持有锁.
+func (rf *rotatingFile) schedule() {
+	if rf.config.Interval <= 0 {
+		return
+	}
+	if rf.timer != nil {
+		rf.timer.Stop()
+	}
+	rf.timer = time.AfterFunc(rf.period.Add(rf.config.Interval).Sub(rf.now()), rf.rotateIdle)
+}
+
+// rotateIdle 周期结束时由定时器调用, 这个周期的文件不再有写入也按时轮转
+func (rf *rotatingFile) rotateIdle() {
+	rf.mutex.Lock()
+	var err error
+	if !rf.closed && rf.file != nil {
+		switch {
+		case rf.shouldRotate(0):
+			err = rf.rotate() // 打开新文件时安排下一次
+		case rf.size == 0:
+			// 空文件不需要轮转, 直接进入新的周期
+			rf.period = rf.truncate(rf.now())
+			rf.schedule()
+		default:
+			rf.schedule()
+		}
+	}
+	rf.mutex.Unlock()
+	if err != nil {
+		logError("日志轮转失败:", err)
+	}
+}
+
+// Close 停止定时器和后台的压缩清理, 关闭当前文件. 正在进行的压缩完成后才返回, 不留下压缩到一半的临时文件.
+// 之后的写入返回os.ErrClosed, 重复调用时什么也不做.
+func (rf *rotatingFile) Close() error {
+	rf.mutex.Lock()
+	if rf.closed {
+		rf.mutex.Unlock()
+		return nil
+	}
+	rf.closed = true
+	if rf.timer != nil {
+		rf.timer.Stop()
+	}
+	var err error
+	if rf.file != nil {
+		err = rf.file.Close()
+		rf.file = nil
+	}
+	// 轮转都在锁中进行, 关闭之后不会再有人发送清理请求
+	close(rf.cleanup)
+	rf.mutex.Unlock()
+	if rf.done != nil {
+		<-rf.done
+	}
+	return err
+}
+
+func (rf *rotatingFile) truncate(t time.Time) time.Time {
+	if rf.config.Interval <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(rf.config.Interval)
+}
+
+func (rf *rotatingFile) shouldRotate(n int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.config.MaxSize > 0 && rf.size+n > rf.config.MaxSize {
+		return true
+	}
+	return rf.config.Interval > 0 && !rf.truncate(rf.now()).Equal(rf.period)
+}
+
+// rotate 关闭当前文件, 改名为带时间戳的文件, 再打开新的当前文件. 调用方需要持有锁.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	name := rf.rotatedName(rf.now())
+	if err := os.Rename(rf.path, name); err != nil {
+		// 改名失败时重新打开原来的文件
+		if openErr := rf.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.scheduleCleanup()
+	return nil
+}
+
+// rotatedName 轮转后的文件名, 同一毫秒内多次轮转时加上序号
+func (rf *rotatingFile) rotatedName(t time.Time) string {
+	dir, prefix, ext := splitLogPath(rf.path)
+	base := filepath.Join(dir, prefix+t.UTC().Format(rotatedTimeLayout))
+	name := base + ext
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return name
+}
+
+func (rf *rotatingFile) scheduleCleanup() {
+	select {
+	case rf.cleanup <- struct{}{}:
+	default:
+		// 已经有一次待执行的清理, 它会处理所有文件
+	}
+}
+
+func (rf *rotatingFile) cleanupLoop() {
+	defer close(rf.done)
+	for range rf.cleanup {
+		if rf.config.Compress {
+			for _, name := range rotatedFiles(rf.path) {
+				if strings.HasSuffix(name, ".gz") {
+					continue
+				}
+				if err := compress(name); err != nil {
+					logError("压缩日志文件失败:", err)
+				}
+			}
+		}
+		rf.prune()
+	}
+}
+
+// prune 按数量和时间删除旧的轮转文件
+func (rf *rotatingFile) prune() {
+	files := rotatedFiles(rf.path) // 新的在前
+	now := rf.now()
+	for i, name := range files {
+		expired := rf.config.MaxAge > 0 && now.Sub(rotatedTime(rf.path, name)) > rf.config.MaxAge
+		if (rf.config.MaxBackups > 0 && i >= rf.config.MaxBackups) || expired {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logError("删除日志文件失败:", err)
+			}
+		}
+	}
+}
+
+// compress 把文件压缩为.gz, 先写临时文件再改名, 压缩到一半时查询不会读到不完整的文件
+func compress(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// splitLogPath 把"dir/distributed_go.log"拆成"dir", "distributed_go-", ".log"
+func splitLogPath(path string) (dir, prefix, ext string) {
+	dir, base := filepath.Split(path)
+	ext = filepath.Ext(base)
+	return dir, strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// rotatedFiles 返回path轮转后的文件, 新的在前
+func rotatedFiles(path string) []string {
+	dir, prefix, ext := splitLogPath(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if !strings.HasSuffix(name, ext) && !strings.HasSuffix(name, ext+".gz") {
+			continue
+		}
+		full := filepath.Join(dir, name)
+		if rotatedTime(path, full).IsZero() {
+			continue
+		}
+		files = append(files, full)
+	}
+	slices.SortFunc(files, func(a, b string) int { return compareRotated(path, a, b) })
+	return files
+}
+
+// compareRotated 按新的在前比较两个轮转后的文件, a更新时返回负数. 时间相同时带序号的文件更新,
+// 序号按数字比较, 与是否已经压缩无关
+func compareRotated(path, a, b string) int {
+	if c := rotatedTime(path, b).Compare(rotatedTime(path, a)); c != 0 {
+		return c
+	}
+	return cmp.Compare(rotatedSeq(path, b), rotatedSeq(path, a))
+}
+
+// rotatedSeq 同一毫秒内多次轮转时文件名中的序号, 没有序号时为0
+func rotatedSeq(path, name string) int {
+	_, prefix, ext := splitLogPath(path)
+	base := strings.TrimPrefix(filepath.Base(name), prefix)
+	if len(base) < len(rotatedTimeLayout) {
+		return 0
+	}
+	rest := strings.TrimSuffix(strings.TrimSuffix(base[len(rotatedTimeLayout):], ".gz"), ext)
+	seq, err := strconv.Atoi(strings.TrimPrefix(rest, "-"))
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// rotatedTime 从轮转后的文件名中取出轮转的时间, 不是轮转文件时返回零值
+func rotatedTime(path, name string) time.Time {
+	_, prefix, _ := splitLogPath(path)
+	base := strings.TrimPrefix(filepath.Base(name), prefix)
+	if len(base) < len(rotatedTimeLayout) {
+		return time.Time{}
+	}
+	t, err := time.Parse(rotatedTimeLayout, base[:len(rotatedTimeLayout)])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,207 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"app.log",                            // 当前文件
+		"app-20261019T120000.000.log.gz",     // 最旧
+		"app-20261019T130000.000.log",        //
+		"app-20261019T130000.000-1.log.gz",   // 同一毫秒的第二次轮转
+		"app-20261019T130000.000-10.log",     // 序号比-9大
+		"app-20261019T130000.000-9.log",      //
+		"app-20261019T140000.000.log",        // 最新
+		"app-20261019T140000.000.log.gz.tmp", // 压缩到一半的临时文件
+		"app-backup.log",                     // 不是轮转的文件
+		"other-20261019T140000.000.log",      // 其他日志文件
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, name := range rotatedFiles(filepath.Join(dir, "app.log")) {
+		got = append(got, filepath.Base(name))
+	}
+	want := []string{
+		"app-20261019T140000.000.log",
+		"app-20261019T130000.000-10.log",
+		"app-20261019T130000.000-9.log",
+		"app-20261019T130000.000-1.log.gz",
+		"app-20261019T130000.000.log",
+		"app-20261019T120000.000.log.gz",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("得到\n%s\n期望\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSplitLogPath(t *testing.T) {
+	tests := []struct {
+		path, dir, prefix, ext string
+	}{
+		{"distributed_go.log", "", "distributed_go-", ".log"},
+		{"/var/log/app.log", "/var/log/", "app-", ".log"},
+		{"noext", "", "noext-", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			dir, prefix, ext := splitLogPath(tt.path)
+			if dir != tt.dir || prefix != tt.prefix || ext != tt.ext {
+				t.Fatalf("splitLogPath = %q, %q, %q", dir, prefix, ext)
+			}
+		})
+	}
+}
+
+// waitFor 等待后台的压缩和清理完成
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RotateConfig
+		writes int
+		files  int // 等待后台任务完成后的轮转文件数量
+		gz     bool
+	}{
+		{"不轮转", RotateConfig{}, 10, 0, false},
+		{"按大小轮转", RotateConfig{MaxSize: 20}, 5, 4, false},
+		{"压缩", RotateConfig{MaxSize: 20, Compress: true}, 5, 4, true},
+		{"按数量保留", RotateConfig{MaxSize: 20, Compress: true, MaxBackups: 2}, 5, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "app.log")
+			rf := newRotatingFile(file, tt.config)
+			t.Cleanup(func() { _ = rf.Close() })
+			for range tt.writes {
+				if _, err := rf.Write([]byte("0123456789abcdef\n")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitFor(t, func() bool {
+				files := rotatedFiles(file)
+				if len(files) != tt.files {
+					return false
+				}
+				for _, name := range files {
+					if strings.HasSuffix(name, ".gz") != tt.gz {
+						return false
+					}
+				}
+				return true
+			})
+			// 每个轮转文件只有一行, 写入的内容没有丢失
+			if tt.config.MaxBackups == 0 {
+				info, _ := os.Stat(file)
+				if total := int(info.Size()) + 17*len(rotatedFiles(file)); total != 17*tt.writes {
+					t.Fatalf("写入了%d字节, 期望%d", total, 17*tt.writes)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	clock := &fakeClock{t: time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC), mutex: &sync.Mutex{}}
+	// 后台的清理也会读取当前时间, 在启动它之前设置时钟
+	rf := &rotatingFile{path: file, config: RotateConfig{Interval: 24 * time.Hour}, mutex: &sync.Mutex{}, now: clock.now, cleanup: make(chan struct{}, 1)}
+	rf.start()
+	t.Cleanup(func() { _ = rf.Close() })
+	_, _ = rf.Write([]byte("a\n"))
+	_, _ = rf.Write([]byte("b\n"))
+	if n := len(rotatedFiles(file)); n != 0 {
+		t.Fatalf("同一天内不应该轮转, 得到%d个文件", n)
+	}
+	clock.add(2 * time.Minute)
+	_, _ = rf.Write([]byte("c\n"))
+	files := rotatedFiles(file)
+	if len(files) != 1 || !strings.Contains(files[0], "app-20261020T000100.000.log") {
+		t.Fatalf("跨天后应该轮转一次, 得到%v", files)
+	}
+}
+
+// TestRotateIdle 周期结束后没有新的写入, 文件也按时轮转; 之后的空文件不再轮转
+func TestRotateIdle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	rf := newRotatingFile(file, RotateConfig{Interval: 50 * time.Millisecond})
+	t.Cleanup(func() { _ = rf.Close() })
+	if _, err := rf.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(rotatedFiles(file)) == 1 })
+	if data, _ := os.ReadFile(rotatedFiles(file)[0]); string(data) != "a\n" {
+		t.Fatalf("轮转后的文件内容是%q", data)
+	}
+	time.Sleep(200 * time.Millisecond) // 又过了几个周期
+	if files := rotatedFiles(file); len(files) != 1 {
+		t.Fatalf("空文件不应该轮转, 得到%v", files)
+	}
+}
+
+func TestRotatingFileClose(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	rf := newRotatingFile(file, RotateConfig{MaxSize: 4, Compress: true, Interval: time.Hour})
+	for range 3 {
+		if _, err := rf.Write([]byte("abc\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close等待后台的压缩完成, 返回时清理的goroutine已经退出
+	select {
+	case <-rf.done:
+	default:
+		t.Fatal("Close返回后后台的压缩和清理还在运行")
+	}
+	if _, err := rf.Write([]byte("d\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("关闭后写入得到%v, 期望os.ErrClosed", err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("重复关闭得到%v", err)
+	}
+	if rf.timer.Stop() {
+		t.Fatal("关闭后轮转的定时器仍然有效")
+	}
+}
+
+// fakeClock 测试中可以调整的时钟
+type fakeClock struct {
+	t     time.Time
+	mutex *sync.Mutex
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.t = c.t.Add(d)
+}
@@ -1,47 +1,78 @@
 package log
 
 import (
+	"DistributedGo/tracing"
+	"encoding/json"
 	"io"
 	stlog "log" // 项目自定义的log变量和标准库的log会有命名冲突, 所以做一个别名
+	"mime"
 	"net/http"
-	"os"
 )
 
 var log *stlog.Logger
 
-// 定义一个类型，实现io.Writer接口，用于写入日志文件. 初始化logger时使用这个自定义的io.Writer
-type fileLog string
+// destination 日志文件的路径, 查询时读取
+var destination string
 
-func (fl fileLog) Write(data []byte) (int, error) {
-	f, err := os.OpenFile(string(fl), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600) // 0600表示文件权限，拥有者可读写
-	if err != nil {
-		return 0, err
-	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			stlog.Println("关闭文件失败:", err)
-		}
-	}(f)
-	return f.Write(data)
+// output log写入的文件, Close时关闭
+var output *rotatingFile
+
+// Run 初始化日志写入路径, 日志文件不轮转. 每条记录写一行JSON, 时间和级别都在记录中, 所以Logger不加前缀.
+func Run(file string) {
+	RunWithRotation(file, RotateConfig{})
+}
+
+// RunWithRotation 初始化日志写入路径, 按config轮转日志文件. 重复调用时先关闭之前的文件
+func RunWithRotation(file string, config RotateConfig) {
+	_ = Close()
+	destination = file
+	output = newRotatingFile(file, config)
+	log = stlog.New(output, "", 0)
 }
 
-// Run 初始化日志写入路径
-func Run(destination string) {
-	log = stlog.New(fileLog(destination), "[Go]- ", stlog.LstdFlags)
+// Close 关闭日志文件, 停止轮转的定时器和后台的压缩清理. 日志服务关闭时调用, 之后写入日志会失败
+func Close() error {
+	if output == nil {
+		return nil
+	}
+	return output.Close()
 }
 
-// RegisterHandlers 注册日志处理器, 用于单独启动的日志Web服务
-func RegisterHandlers() {
-	http.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+// RegisterHandlers 在服务自己的ServeMux上注册日志处理器, 用于单独启动的日志Web服务.
+// POST /log 的请求体是JSON格式的Record; 其他Content-Type按旧客户端的纯文本处理, 整个请求体作为一条消息.
+// GET /log 查询日志文件中的记录, 见serveQuery.
+func RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			msg, err := io.ReadAll(r.Body)
-			if err != nil || len(msg) == 0 {
-				http.Error(w, "Invalid request body", http.StatusBadRequest)
+			var record Record
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+				if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+					http.Error(w, "Invalid request body", http.StatusBadRequest)
+					return
+				}
+			} else {
+				msg, err := io.ReadAll(r.Body)
+				if err != nil || len(msg) == 0 {
+					http.Error(w, "Invalid request body", http.StatusBadRequest)
+					return
+				}
+				record = parseLegacy(string(msg))
+				// 旧客户端在请求头中传递追踪上下文. 不能使用r.Context(), 其中的span是日志服务为这次请求新建的
+				if sc, ok := tracing.Parse(r.Header.Get(tracing.TraceparentHeader)); ok {
+					record.TraceID = sc.TraceID
+				}
+			}
+			if err := record.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := write(record); err != nil {
+				http.Error(w, "Failed to write log", http.StatusInternalServerError)
 				return
 			}
-			write(string(msg))
+		case http.MethodGet:
+			serveQuery(w, r)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -49,6 +80,10 @@ func RegisterHandlers() {
 	})
 }
 
-func write(message string) {
-	log.Printf("%v\n", message) // %v 表示按默认格式输出, 是一个通用的占位符
+func write(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return log.Output(2, string(data))
 }
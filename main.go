@@ -1,5 +1,98 @@
 package main
 
+import (
+	"DistributedGo/app"
+	"DistributedGo/services"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// 开发模式: 在一个进程中启动注册中心、TracingService、日志服务和成绩服务, go run . 即可运行整个系统.
+// 组件按依赖顺序启动, 收到SIGINT/SIGTERM后按相反的顺序逐个关闭, 每个组件关闭完成后再关闭下一个.
+// 需要单独部署时使用cmd下对应的命令.
+
+// component 一个已经启动的组件
+type component struct {
+	name   string
+	cancel context.CancelFunc
+	done   context.Context
+}
+
 func main() {
+	runRegistry := flag.Bool("registry", true, "启动服务注册中心")
+	runTracing := flag.Bool("tracing", true, "启动TracingService")
+	runLog := flag.Bool("log", true, "启动日志服务")
+	runGrading := flag.Bool("grading", true, "启动成绩服务")
+	logFile := flag.String("log-file", "distributed_go.log", "日志服务写入的文件")
+	useTLS := flag.Bool("tls", false, "服务使用自签名证书提供HTTPS, 注册中心仍然使用HTTP")
+	adminToken := flag.String("admin-token", os.Getenv("DISTRIBUTEDGO_ADMIN_TOKEN"), "不为空时在每个组件的端口上启用管理接口(/debug/), 使用这个token认证")
+	stdin := flag.Bool("stdin", false, "允许在标准输入中输入任意内容关闭所有组件")
+	rotateConfig := app.LogRotateFlags()
+	flag.Parse()
+
+	var opts app.ServiceOptions
+	if *useTLS {
+		opts.TLS = &services.TLSConfig{SelfSigned: true}
+		services.InsecureSkipVerify()
+	}
+	var registryOpts app.RegistryOptions
+	if *adminToken != "" {
+		opts.Admin = &services.AdminConfig{Token: *adminToken}
+		registryOpts.Admin = opts.Admin
+	}
+
+	ctx, stop := app.ShutdownContext(*stdin)
+	defer stop()
+
+	var started []component
+	start := func(name string, run func(ctx context.Context) (context.Context, error)) bool {
+		componentCtx, cancel := context.WithCancel(context.Background())
+		done, err := run(componentCtx)
+		if done != nil {
+			started = append(started, component{name: name, cancel: cancel, done: done})
+		} else {
+			cancel()
+		}
+		if err != nil {
+			log.Printf("启动%s失败: %v\n", name, err)
+			return false
+		}
+		return true
+	}
+
+	ok := true
+	if *runRegistry {
+		ok = start("服务注册中心", func(ctx context.Context) (context.Context, error) {
+			return app.RunRegistry(ctx, registryOpts)
+		})
+	}
+	if ok && *runTracing {
+		ok = start("TracingService", func(ctx context.Context) (context.Context, error) {
+			return app.RunTracingService(ctx, opts)
+		})
+	}
+	if ok && *runLog {
+		ok = start("日志服务", func(ctx context.Context) (context.Context, error) {
+			return app.RunLogService(ctx, *logFile, rotateConfig(), opts)
+		})
+	}
+	if ok && *runGrading {
+		ok = start("成绩服务", func(ctx context.Context) (context.Context, error) {
+			return app.RunGradingService(ctx, opts)
+		})
+	}
+	if ok && len(started) > 0 {
+		<-ctx.Done()
+	}
 
+	// 按依赖关系的逆序关闭: 成绩服务 -> 日志服务 -> TracingService -> 注册中心
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		c.cancel()
+		<-c.done.Done()
+		fmt.Printf("%s已关闭\n", c.name)
+	}
 }
@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 负载均衡策略. 一个服务依赖的每个服务都可以在注册时通过RegistrationEntry.LoadBalancing选择自己的策略,
+// 没有指定时使用随机策略. 也可以通过SetBalancer设置自定义的Balancer实现.
+
+// Instance 依赖服务的一个实例
+type Instance struct {
+	URL    string
+	Weight int // 实例权重, 小于等于0时按1处理
+}
+
+// Balancer 负载均衡策略, 从可用实例中选出一个. 实现需要是并发安全的.
+type Balancer interface {
+	// Pick key是请求的路由键(如学生ID), 只有一致性哈希策略使用它, 其他策略忽略.
+	Pick(instances []Instance, key string) (Instance, error)
+}
+
+// Releaser 需要知道请求何时结束的策略(如最少未完成请求)额外实现这个接口, 请求结束后调用Release.
+// GetProvider选出实例后立即结束, 这些策略只对PickProvider和Transport发出的请求有效.
+type Releaser interface {
+	Release(url string)
+}
+
+// Pruner 按实例保存状态的策略额外实现这个接口. 依赖服务的实例列表变化后调用Prune, 删除已经下线的实例的状态,
+// 端口随机分配的实例不断上线下线时, 状态不会无限增长.
+type Pruner interface {
+	Prune(instances []Instance)
+}
+
+type BalancerType string
+
+const (
+	Random           BalancerType = "random"
+	RoundRobin       BalancerType = "round-robin"
+	Weighted         BalancerType = "weighted"
+	LeastOutstanding BalancerType = "least-outstanding" // 需要PickProvider或者Transport统计未完成的请求
+	PowerOfTwo       BalancerType = "power-of-two"      // 同上
+	ConsistentHash   BalancerType = "consistent-hash"
+)
+
+var errNoInstance = errors.New("没有可用的服务实例")
+
+// NewBalancer 根据策略类型创建Balancer, 未知的类型使用随机策略.
+func NewBalancer(t BalancerType) Balancer {
+	switch t {
+	case RoundRobin:
+		return &roundRobin{}
+	case Weighted:
+		return &weighted{current: make(map[string]int), mutex: &sync.Mutex{}}
+	case LeastOutstanding:
+		return &leastOutstanding{outstanding: newOutstanding()}
+	case PowerOfTwo:
+		return &powerOfTwo{outstanding: newOutstanding()}
+	case ConsistentHash:
+		return &consistentHash{mutex: &sync.Mutex{}}
+	default:
+		return random{}
+	}
+}
+
+type random struct{}
+
+func (random) Pick(instances []Instance, _ string) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, errNoInstance
+	}
+	return instances[rand.Intn(len(instances))], nil
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (rr *roundRobin) Pick(instances []Instance, _ string) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, errNoInstance
+	}
+	n := rr.next.Add(1) - 1
+	return instances[n%uint64(len(instances))], nil
+}
+
+// weighted 平滑加权轮询(和nginx相同的算法): 每次给所有实例加上自己的权重, 选出当前值最大的实例并减去总权重.
+// 权重大的实例被选中的次数多, 而且选中的顺序是分散的.
+type weighted struct {
+	current map[string]int
+	mutex   *sync.Mutex
+}
+
+func (w *weighted) Pick(instances []Instance, _ string) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, errNoInstance
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	total, best := 0, -1
+	for i, ins := range instances {
+		weight := max(ins.Weight, 1)
+		total += weight
+		w.current[ins.URL] += weight
+		if best < 0 || w.current[ins.URL] > w.current[instances[best].URL] {
+			best = i
+		}
+	}
+	w.current[instances[best].URL] -= total
+	return instances[best], nil
+}
+
+func (w *weighted) Prune(instances []Instance) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	pruneKeys(w.current, instances)
+}
+
+// pruneKeys 删除m中不在instances里的实例
+func pruneKeys[V any](m map[string]V, instances []Instance) {
+	for url := range m {
+		if !slices.ContainsFunc(instances, func(ins Instance) bool { return ins.URL == url }) {
+			delete(m, url)
+		}
+	}
+}
+
+// outstanding 记录每个实例未完成的请求数量
+type outstanding struct {
+	counts map[string]int
+	mutex  *sync.Mutex
+}
+
+func newOutstanding() outstanding {
+	return outstanding{counts: make(map[string]int), mutex: &sync.Mutex{}}
+}
+
+func (o outstanding) Release(url string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.counts[url] > 0 {
+		o.counts[url]--
+	}
+	if o.counts[url] == 0 {
+		delete(o.counts, url)
+	}
+}
+
+// Prune 下线的实例上还没有结束的请求之后调用Release时, 计数已经不存在, 不会变成负数
+func (o outstanding) Prune(instances []Instance) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	pruneKeys(o.counts, instances)
+}
+
+type leastOutstanding struct {
+	outstanding
+}
+
+func (lo *leastOutstanding) Pick(instances []Instance, _ string) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, errNoInstance
+	}
+	lo.mutex.Lock()
+	defer lo.mutex.Unlock()
+	// 从随机位置开始找, 未完成请求数相同时不会总是选中第一个实例
+	start := rand.Intn(len(instances))
+	best := start
+	for i := range instances {
+		idx := (start + i) % len(instances)
+		if lo.counts[instances[idx].URL] < lo.counts[instances[best].URL] {
+			best = idx
+		}
+	}
+	lo.counts[instances[best].URL]++
+	return instances[best], nil
+}
+
+// powerOfTwo 随机选两个实例, 取未完成请求少的那个. 效果接近最少未完成请求, 但不需要比较所有实例.
+type powerOfTwo struct {
+	outstanding
+}
+
+func (p *powerOfTwo) Pick(instances []Instance, _ string) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, errNoInstance
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	a := rand.Intn(len(instances))
+	b := a
+	if len(instances) > 1 {
+		b = (a + 1 + rand.Intn(len(instances)-1)) % len(instances)
+	}
+	if p.counts[instances[b].URL] < p.counts[instances[a].URL] {
+		a = b
+	}
+	p.counts[instances[a].URL]++
+	return instances[a], nil
+}
+
+// consistentHash 一致性哈希, 相同key的请求落到同一个实例上. 每个实例按权重在哈希环上放置若干虚拟节点,
+// 实例增减时只有相邻区间的key会迁移. 没有key的请求随机选择.
+type consistentHash struct {
+	members string // 构造哈希环时的实例列表, 实例变化后重建哈希环
+	ring    []uint32
+	owners  map[uint32]Instance
+	mutex   *sync.Mutex
+}
+
+const virtualNodes = 100
+
+func (c *consistentHash) Pick(instances []Instance, key string) (Instance, error) {
+	if len(instances) == 0 {
+		return Instance{}, errNoInstance
+	}
+	if key == "" {
+		return random{}.Pick(instances, key)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rebuild(instances)
+	h := crc32.ChecksumIEEE([]byte(key))
+	i, _ := slices.BinarySearch(c.ring, h)
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.owners[c.ring[i]], nil
+}
+
+// Prune 实例变化后释放旧的哈希环, 下一次Pick时按新的实例列表重建
+func (c *consistentHash) Prune(instances []Instance) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if members(instances) != c.members {
+		c.members, c.ring, c.owners = "", nil, nil
+	}
+}
+
+func members(instances []Instance) string {
+	members := ""
+	for _, ins := range instances {
+		members += ins.URL + "#" + strconv.Itoa(ins.Weight) + ","
+	}
+	return members
+}
+
+func (c *consistentHash) rebuild(instances []Instance) {
+	m := members(instances)
+	if m == c.members {
+		return
+	}
+	c.members = m
+	c.ring = c.ring[:0]
+	c.owners = make(map[uint32]Instance)
+	for _, ins := range instances {
+		for v := 0; v < virtualNodes*max(ins.Weight, 1); v++ {
+			h := crc32.ChecksumIEEE([]byte(ins.URL + "#" + strconv.Itoa(v)))
+			c.ring = append(c.ring, h)
+			c.owners[h] = ins
+		}
+	}
+	slices.Sort(c.ring)
+}
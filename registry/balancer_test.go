@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBalancerEmpty(t *testing.T) {
+	for _, bt := range []BalancerType{Random, RoundRobin, Weighted, LeastOutstanding, PowerOfTwo, ConsistentHash} {
+		t.Run(string(bt), func(t *testing.T) {
+			if _, err := NewBalancer(bt).Pick(nil, "key"); err == nil {
+				t.Fatal("没有实例时应该返回错误")
+			}
+		})
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	instances := []Instance{{URL: "a"}, {URL: "b"}, {URL: "c"}}
+	b := NewBalancer(RoundRobin)
+	var got string
+	for range 6 {
+		ins, _ := b.Pick(instances, "")
+		got += ins.URL
+	}
+	if got != "abcabc" {
+		t.Fatalf("轮询顺序%s", got)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []Instance
+		want      string
+	}{
+		{"平滑加权", []Instance{{URL: "a", Weight: 5}, {URL: "b", Weight: 1}, {URL: "c", Weight: 1}}, "aabacaa"},
+		{"权重为0按1处理", []Instance{{URL: "a"}, {URL: "b", Weight: 1}}, "abab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBalancer(Weighted)
+			var got string
+			for range len(tt.want) {
+				ins, _ := b.Pick(tt.instances, "")
+				got += ins.URL
+			}
+			if got != tt.want {
+				t.Fatalf("选择顺序%s, 期望%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	instances := []Instance{{URL: "a"}, {URL: "b"}, {URL: "c"}}
+	b := NewBalancer(LeastOutstanding)
+	picked := make(map[string]int)
+	for range 3 {
+		ins, _ := b.Pick(instances, "")
+		picked[ins.URL]++
+	}
+	if len(picked) != 3 {
+		t.Fatalf("3个未结束的请求应该分到3个实例, 得到%v", picked)
+	}
+	b.(Releaser).Release("b")
+	if ins, _ := b.Pick(instances, ""); ins.URL != "b" {
+		t.Fatalf("应该选择未完成请求最少的b, 得到%s", ins.URL)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	instances := []Instance{{URL: "a"}, {URL: "b"}, {URL: "c"}}
+	b := NewBalancer(ConsistentHash)
+	owners := make(map[string]string)
+	for i := range 100 {
+		key := fmt.Sprint("student-", i)
+		ins, _ := b.Pick(instances, key)
+		if again, _ := b.Pick(instances, key); again.URL != ins.URL {
+			t.Fatalf("相同的key选择了不同的实例: %s %s", ins.URL, again.URL)
+		}
+		owners[key] = ins.URL
+	}
+	// 删除一个实例后, 只有原来属于它的key迁移
+	moved := 0
+	for key, owner := range owners {
+		ins, _ := b.Pick(instances[:2], key)
+		if owner != "c" && ins.URL != owner {
+			t.Fatalf("%s从%s迁移到了%s", key, owner, ins.URL)
+		}
+		if ins.URL != owner {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Fatal("属于c的key应该迁移")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	instances := []Instance{{URL: "a"}, {URL: "b"}}
+	tests := []struct {
+		bt   BalancerType
+		size func(Balancer) int
+	}{
+		{Weighted, func(b Balancer) int { return len(b.(*weighted).current) }},
+		{LeastOutstanding, func(b Balancer) int { return len(b.(*leastOutstanding).counts) }},
+		{PowerOfTwo, func(b Balancer) int { return len(b.(*powerOfTwo).counts) }},
+		{ConsistentHash, func(b Balancer) int { return len(b.(*consistentHash).owners) }},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.bt), func(t *testing.T) {
+			b := NewBalancer(tt.bt)
+			for i := range 10 {
+				_, _ = b.Pick(instances, fmt.Sprint(i))
+			}
+			b.(Pruner).Prune(instances[:1])
+			if tt.bt == ConsistentHash {
+				if n := tt.size(b); n != 0 {
+					t.Fatalf("实例变化后应该释放哈希环, 还有%d个节点", n)
+				}
+				return
+			}
+			if n := tt.size(b); n > 1 {
+				t.Fatalf("Prune后还保留%d个实例的状态", n)
+			}
+		})
+	}
+}
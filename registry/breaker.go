@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 客户端熔断器. 每个依赖服务的实例URL对应一个熔断器, 根据调用结果在三种状态之间切换:
+// closed(正常) -> 连续失败次数或者窗口内的错误率超过阈值 -> open(熔断, 选择实例时跳过)
+// open -> 熔断时间到 -> half-open(放行一个探测请求) -> 成功则closed, 失败则重新open
+// 探测请求在breakerProbeTimeout内没有上报结果(调用方没有调用done)时, 放行下一个探测请求, 熔断器不会一直停在半开状态.
+// 这样一个卡住的实例不需要等注册中心的健康检查把它下线, 调用方很快就不再请求它.
+
+const (
+	breakerConsecutiveFailures = 5                // 连续失败多少次后熔断
+	breakerErrorRate           = 0.5              // 窗口内错误率超过多少后熔断
+	breakerMinRequests         = 10               // 窗口内请求数至少达到多少才按错误率判断
+	breakerWindow              = 30 * time.Second // 统计错误率的窗口
+	breakerOpenTimeout         = 10 * time.Second // 熔断多久后进入半开状态
+	breakerProbeTimeout        = 10 * time.Second // 探测请求多久没有结果后放行新的探测请求
+)
+
+var ErrCircuitOpen = errors.New("服务的所有实例都处于熔断状态")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+type breaker struct {
+	service     ServiceName
+	state       BreakerState
+	consecutive int // 连续失败次数
+	requests    int // 当前窗口内的请求数
+	failures    int // 当前窗口内的失败数
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool      // 半开状态下是否已经放行了探测请求
+	probeAt     time.Time // 放行探测请求的时间
+	opens       int       // 累计熔断次数
+}
+
+// ready 判断实例当前是否可以被选择, 不改变熔断器状态.
+func (b *breaker) ready(now time.Time) bool {
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.openedAt) >= breakerOpenTimeout
+	case BreakerHalfOpen:
+		return !b.probing || now.Sub(b.probeAt) >= breakerProbeTimeout
+	default:
+		return true
+	}
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.probing = false
+	b.opens++
+}
+
+func (b *breaker) close(now time.Time) {
+	b.state = BreakerClosed
+	b.consecutive, b.requests, b.failures = 0, 0, 0
+	b.windowStart = now
+	b.probing = false
+}
+
+type breakers struct {
+	urls  map[string]*breaker
+	mutex *sync.Mutex
+}
+
+// choose 跳过熔断中的实例, 用pick从剩下的实例中选出一个. claim为true时选中的实例占用半开状态的探测名额.
+// 过滤、选择和占用在同一个锁内完成, 两个并发的调用方不会同时成为探测请求.
+func (bs *breakers) choose(name ServiceName, instances []Instance, claim bool, pick func([]Instance) (Instance, error)) (Instance, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	now := time.Now()
+	available := make([]Instance, 0, len(instances))
+	for _, ins := range instances {
+		if b, ok := bs.urls[ins.URL]; !ok || b.ready(now) {
+			available = append(available, ins)
+		}
+	}
+	if len(available) == 0 {
+		return Instance{}, fmt.Errorf("%w: %s", ErrCircuitOpen, name)
+	}
+	ins, err := pick(available)
+	if err != nil || !claim {
+		return ins, err
+	}
+	b := bs.get(name, ins.URL, now)
+	if b.state == BreakerOpen {
+		b.state = BreakerHalfOpen
+	}
+	if b.state == BreakerHalfOpen {
+		b.probing, b.probeAt = true, now
+	}
+	return ins, nil
+}
+
+// get 返回实例的熔断器, 不存在时创建. 调用方需要持有锁.
+func (bs *breakers) get(name ServiceName, url string, now time.Time) *breaker {
+	b, ok := bs.urls[url]
+	if !ok {
+		b = &breaker{service: name, state: BreakerClosed, windowStart: now}
+		bs.urls[url] = b
+	}
+	return b
+}
+
+// record 记录一次调用的结果并更新熔断器状态.
+func (bs *breakers) record(name ServiceName, url string, err error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	now := time.Now()
+	b := bs.get(name, url, now)
+	switch {
+	case b.state == BreakerHalfOpen, b.state == BreakerOpen && b.ready(now):
+		// 探测请求的结果. 通过GetProvider选择的实例不经过半开状态, 熔断时间到之后的第一个结果同样作为探测结果
+		if err == nil {
+			b.close(now)
+		} else {
+			b.open(now)
+		}
+		return
+	case b.state == BreakerOpen:
+		// 熔断之前发出的请求陆续返回, 不影响状态
+		return
+	}
+	if now.Sub(b.windowStart) > breakerWindow {
+		b.requests, b.failures = 0, 0
+		b.windowStart = now
+	}
+	b.requests++
+	if err == nil {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+	if b.consecutive >= breakerConsecutiveFailures ||
+		(b.requests >= breakerMinRequests && float64(b.failures)/float64(b.requests) >= breakerErrorRate) {
+		b.open(now)
+	}
+}
+
+// forget 实例从provider中移除后, 熔断器也不再需要
+func (bs *breakers) forget(url string) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	delete(bs.urls, url)
+}
+
+// BreakerStats 熔断器的状态, 通过/metrics接口对外展示
+type BreakerStats struct {
+	Service     ServiceName
+	URL         string
+	State       BreakerState
+	Consecutive int
+	Requests    int
+	Failures    int
+	Opens       int
+}
+
+func (bs *breakers) stats() []BreakerStats {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	now := time.Now()
+	stats := make([]BreakerStats, 0, len(bs.urls))
+	for url, b := range bs.urls {
+		state := b.state
+		if state == BreakerOpen && b.ready(now) {
+			state = BreakerHalfOpen
+		}
+		stats = append(stats, BreakerStats{
+			Service:     b.service,
+			URL:         url,
+			State:       state,
+			Consecutive: b.consecutive,
+			Requests:    b.requests,
+			Failures:    b.failures,
+			Opens:       b.opens,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
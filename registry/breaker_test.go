@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newBreakers() *breakers {
+	return &breakers{urls: make(map[string]*breaker), mutex: &sync.Mutex{}}
+}
+
+func TestBreakerRecord(t *testing.T) {
+	fail := errors.New("fail")
+	tests := []struct {
+		name    string
+		results []error
+		want    BreakerState
+	}{
+		{"全部成功", []error{nil, nil, nil}, BreakerClosed},
+		{"连续失败4次", []error{fail, fail, fail, fail}, BreakerClosed},
+		{"连续失败5次", []error{fail, fail, fail, fail, fail}, BreakerOpen},
+		{"成功打断连续失败", []error{fail, fail, fail, fail, nil, fail, fail, fail, fail}, BreakerClosed},
+		{"错误率达到一半", []error{nil, fail, nil, fail, nil, fail, nil, fail, nil, fail}, BreakerOpen},
+		{"请求数不足时不按错误率", []error{fail, nil, fail, nil, fail, nil}, BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bs := newBreakers()
+			for _, err := range tt.results {
+				bs.record("Svc", "http://a", err)
+			}
+			if got := bs.urls["http://a"].state; got != tt.want {
+				t.Fatalf("状态为%s, 期望%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// expire 让熔断中的实例到达半开的时间
+func expire(bs *breakers, url string) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.urls[url].openedAt = time.Now().Add(-breakerOpenTimeout)
+}
+
+func openBreaker(bs *breakers, url string) {
+	for range breakerConsecutiveFailures {
+		bs.record("Svc", url, errors.New("fail"))
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	first := func(instances []Instance) (Instance, error) { return instances[0], nil }
+	instances := []Instance{{URL: "http://a"}}
+	tests := []struct {
+		name  string
+		probe error
+		want  BreakerState
+	}{
+		{"探测成功后恢复", nil, BreakerClosed},
+		{"探测失败后继续熔断", errors.New("fail"), BreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bs := newBreakers()
+			openBreaker(bs, "http://a")
+			if _, err := bs.choose("Svc", instances, true, first); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("熔断时间没到时应该返回ErrCircuitOpen, 得到%v", err)
+			}
+			expire(bs, "http://a")
+			if _, err := bs.choose("Svc", instances, true, first); err != nil {
+				t.Fatalf("熔断时间到了应该放行探测请求: %v", err)
+			}
+			if _, err := bs.choose("Svc", instances, true, first); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("探测期间不应该放行第二个请求, 得到%v", err)
+			}
+			bs.record("Svc", "http://a", tt.probe)
+			if got := bs.urls["http://a"].state; got != tt.want {
+				t.Fatalf("状态为%s, 期望%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBreakerSingleProbe 并发的调用方中只有一个成为探测请求
+func TestBreakerSingleProbe(t *testing.T) {
+	bs := newBreakers()
+	openBreaker(bs, "http://a")
+	expire(bs, "http://a")
+	instances := []Instance{{URL: "http://a"}}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	claimed := 0
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := bs.choose("Svc", instances, true, func(instances []Instance) (Instance, error) { return instances[0], nil })
+			if err == nil {
+				mutex.Lock()
+				claimed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("%d个调用方成为探测请求, 期望1个", claimed)
+	}
+}
+
+// TestBreakerProbeTimeout 探测请求没有上报结果时, 超时后放行下一个探测请求
+func TestBreakerProbeTimeout(t *testing.T) {
+	bs := newBreakers()
+	openBreaker(bs, "http://a")
+	expire(bs, "http://a")
+	instances := []Instance{{URL: "http://a"}}
+	first := func(instances []Instance) (Instance, error) { return instances[0], nil }
+	if _, err := bs.choose("Svc", instances, true, first); err != nil {
+		t.Fatal(err)
+	}
+	bs.mutex.Lock()
+	bs.urls["http://a"].probeAt = time.Now().Add(-breakerProbeTimeout)
+	bs.mutex.Unlock()
+	if _, err := bs.choose("Svc", instances, true, first); err != nil {
+		t.Fatalf("探测超时后应该放行新的探测请求: %v", err)
+	}
+}
+
+// TestBreakerReportWithoutClaim GetProvider的调用方通过Report上报时, 熔断时间到之后的结果作为探测结果
+func TestBreakerReportWithoutClaim(t *testing.T) {
+	bs := newBreakers()
+	openBreaker(bs, "http://a")
+	bs.record("Svc", "http://a", nil)
+	if got := bs.urls["http://a"].state; got != BreakerOpen {
+		t.Fatalf("熔断时间没到时结果不影响状态, 得到%s", got)
+	}
+	expire(bs, "http://a")
+	bs.record("Svc", "http://a", nil)
+	if got := bs.urls["http://a"].state; got != BreakerClosed {
+		t.Fatalf("熔断时间到之后成功应该恢复, 得到%s", got)
+	}
+}
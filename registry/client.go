@@ -2,35 +2,193 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
+	"maps"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 )
 
-// 需要注册服务到服务中心的服务调用这里提供的方法进行注册, DRY.
-// 该方法会对服务注册中心发送一个HTTP.POST请求进行服务注册.
+// ClientOptions 客户端的配置, 零值即可使用
+type ClientOptions struct {
+	// RegistryAddr 注册中心的地址, 为空时使用 "http://localhost" + ServerPort
+	RegistryAddr string
+	// HTTPClient 访问注册中心和上报健康信号使用的客户端, 为nil时新建一个
+	HTTPClient *http.Client
+	// Mux 注册服务时添加更新、健康检查和/metrics handler的ServeMux, 为nil时新建一个.
+	// 服务需要使用同一个ServeMux处理请求, 可以通过Client.Mux获取.
+	Mux *http.ServeMux
+	// Heartbeat 处理注册中心健康检查请求的handler, 返回200表示健康. 为nil时总是返回200.
+	Heartbeat http.Handler
+	// MetricsPath 运行状态接口的路径, 为空时使用MetricsPath. 转发请求的服务(sidecar、网关)需要换一个路径, 避免遮住被转发的/metrics
+	MetricsPath string
+}
 
-func RegisterService(re RegistrationEntry) error {
+// Client 注册中心的客户端. providers、熔断器、异常检测、续约goroutine、ServeMux和HTTP客户端都由它自己持有,
+// 同一个进程中的多个服务可以各自使用一个Client. 包级的函数使用默认的Client, 它的handler添加在http.DefaultServeMux上.
+type Client struct {
+	servicesURL string
+	signalsURL  string
+	httpClient  *http.Client
+	mux         *http.ServeMux
+	heartbeat   http.Handler
+	metricsPath string
+	handled     map[string]bool // 已经添加过handler的路径, 重新注册时不重复添加
+	name        ServiceName     // 注册的服务名, 记录span时使用
+	instance    string          // 注册的实例ID, 发送日志时使用
+	mutex       *sync.Mutex
+	keep        *keeper
+	prov        *providers
+	brk         *breakers
+	outliers    *outlierDetector
+}
+
+func NewClient(opts ClientOptions) *Client {
+	addr := opts.RegistryAddr
+	if addr == "" {
+		addr = "http://localhost" + ServerPort
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	metricsPath := opts.MetricsPath
+	if metricsPath == "" {
+		metricsPath = MetricsPath
+	}
+	mux := opts.Mux
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	c := &Client{
+		servicesURL: addr + "/services",
+		signalsURL:  addr + "/signals",
+		httpClient:  httpClient,
+		mux:         mux,
+		heartbeat:   opts.Heartbeat,
+		metricsPath: metricsPath,
+		handled:     make(map[string]bool),
+		mutex:       &sync.Mutex{},
+		keep:        newKeeper(),
+		prov: &providers{
+			services:    make(map[ServiceName][]Instance),
+			balancers:   make(map[ServiceName]Balancer),
+			mutex:       &sync.RWMutex{},
+			changed:     make(chan struct{}),
+			subscribers: make(map[ServiceName]map[*subscription]bool),
+			applied:     make(map[string]appliedPatch),
+		},
+		brk: &breakers{
+			urls:  make(map[string]*breaker),
+			mutex: &sync.Mutex{},
+		},
+	}
+	c.outliers = &outlierDetector{
+		services: make(map[ServiceName]map[string]*outlierState),
+		report:   c.reportSignal,
+		mutex:    &sync.Mutex{},
+	}
+	return c
+}
+
+// Mux 返回添加了客户端handler的ServeMux
+func (c *Client) Mux() *http.ServeMux {
+	return c.mux
+}
+
+// HTTPClient 返回一个通过本客户端的服务发现解析服务名的http.Client
+func (c *Client) HTTPClient() *http.Client {
+	return &http.Client{Transport: &Transport{Client: c}}
+}
+
+// handle 在mux上添加handler, 同一个路径只添加一次. 重复添加会让ServeMux panic.
+func (c *Client) handle(path string, handler http.Handler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.handled[path] {
+		return
+	}
+	c.handled[path] = true
+	c.mux.Handle(path, handler)
+}
+
+// SetHeartbeat 设置处理注册中心健康检查请求的handler, 与ClientOptions.Heartbeat相同. 需要在Register之前调用.
+func (c *Client) SetHeartbeat(h http.Handler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.heartbeat = h
+}
+
+// serviceName 注册的服务名, 还没有注册时返回空
+func (c *Client) serviceName() ServiceName {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.name
+}
+
+// InstanceID 注册的实例ID, 还没有注册时返回空
+func (c *Client) InstanceID() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.instance
+}
+
+// Register 需要注册服务到服务中心的服务调用这里提供的方法进行注册, DRY.
+// 该方法会对服务注册中心发送一个HTTP.POST请求进行服务注册.
+func (c *Client) Register(re RegistrationEntry) error {
+	if re.ProtocolVersion == 0 {
+		re.ProtocolVersion = ProtocolVersion
+	}
 	// 在注册服务时, 添加回调接收服务更新通知的handler.
 	serviceUpdateUrl, err := url.Parse(re.ServiceUpdateURL)
 	if err != nil {
 		return fmt.Errorf("服务更新URL解析失败: %s, 错误: %v", re.ServiceUpdateURL, err)
 	}
-	http.Handle(serviceUpdateUrl.Path, new(serviceUpdateHandler))
+	c.handle(serviceUpdateUrl.Path, &serviceUpdateHandler{prov: c.prov, brk: c.brk, outliers: c.outliers})
 	// 添加健康检查的 handler
 	heartbeatUrl, err := url.Parse(re.HeartbeatURL)
 	if err != nil {
 		return fmt.Errorf("服务更新URL解析失败: %s, 错误: %v", re.HeartbeatURL, err)
 	}
-	http.HandleFunc(heartbeatUrl.Path, func(w http.ResponseWriter, r *http.Request) {
+	c.handle(heartbeatUrl.Path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 记录注册中心最近一次的心跳, 长时间收不到心跳说明注册中心可能丢失了本服务.
+		c.keep.touch()
+		c.mutex.Lock()
+		heartbeat := c.heartbeat
+		c.mutex.Unlock()
+		if heartbeat != nil {
+			heartbeat.ServeHTTP(w, r)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		// 可以返回一些服务的状态信息, 这里简单起见, 只返回200状态码.
-	})
+	}))
+	c.handle(c.metricsPath, http.HandlerFunc(c.metricsHandler))
+	c.outliers.setReporter(re.ServiceName)
+	c.mutex.Lock()
+	c.name, c.instance = re.ServiceName, re.InstanceID
+	c.mutex.Unlock()
+	// 依赖服务的负载均衡策略需要在收到依赖信息之前设置好
+	for name, t := range re.LoadBalancing {
+		c.prov.setBalancer(name, NewBalancer(t))
+	}
+	if err := c.register(re); err != nil {
+		return err
+	}
+	// 注册成功后持续续约, 注册中心重启或丢失状态时自动重新注册.
+	c.keep.start(re, c.keepAlive)
+	return nil
+}
+
+// register 向服务注册中心发送注册请求, 并记录注册中心的纪元ID. 重新注册时也调用这个方法.
+func (c *Client) register(re RegistrationEntry) error {
 	// POST请求需要一个io.Reader类型的body参数.可以这样构造:
 	// buffer是一个实现了io.Writer接口和io.Reader接口的类型.使用json.Encoder可以直接将结构体编码到buffer中.
 	// 然后将buffer作为POST请求的body参数传递, 作为io.Reader使用.
@@ -40,7 +198,7 @@ func RegisterService(re RegistrationEntry) error {
 		return err
 	}
 	// 2. 发送POST请求到服务注册中心.
-	res, err := http.Post(ServicesURL, "application/json", buffer)
+	res, err := c.httpClient.Post(c.servicesURL, "application/json", buffer)
 	if err != nil {
 		return err
 	}
@@ -51,25 +209,30 @@ func RegisterService(re RegistrationEntry) error {
 		}
 	}(res.Body)
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("服务注册失败, 状态码: %d, 服务: %s:%s", res.StatusCode, re.ServiceName, re.ServiceURL)
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("服务注册失败, 状态码: %d, 服务: %s:%s, %s", res.StatusCode, re.ServiceName, re.ServiceURL, strings.TrimSpace(string(msg)))
 	}
+	c.keep.setEpoch(res.Header.Get(EpochHeader))
+	c.keep.touch()
 	return nil
 }
 
-func DeregisterService(re RegistrationEntry) error {
+func (c *Client) Deregister(re RegistrationEntry) error {
+	// 主动注销后不再续约, 否则会被重新注册回去.
+	c.keep.stop(re)
 	// http包没有直接提供DELETE方法, 需要通过NewRequest来创建请求.
 	buffer := bytes.NewBuffer(nil)
 	encoder := json.NewEncoder(buffer)
 	if err := encoder.Encode(re); err != nil {
 		return err
 	}
-	req, err := http.NewRequest(http.MethodDelete, ServicesURL, buffer)
+	req, err := http.NewRequest(http.MethodDelete, c.servicesURL, buffer)
 	if err != nil {
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -86,8 +249,40 @@ func DeregisterService(re RegistrationEntry) error {
 	return nil
 }
 
+// Watch 阻塞直到注册中心的注册列表版本不等于version或者等待超时, 返回服务name的实例, name为空时返回全部服务.
+// 和RPC的Watch行为一致, 供需要整个注册列表的客户端(如网关)使用. 第一次调用时version传0.
+func (c *Client) Watch(ctx context.Context, name ServiceName, version uint64) (ListReply, error) {
+	var reply ListReply
+	query := url.Values{"name": {string(name)}, "watch": {strconv.FormatUint(version, 10)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.servicesURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return reply, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return reply, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return reply, fmt.Errorf("查询注册列表失败, 状态码: %d", res.StatusCode)
+	}
+	err = json.NewDecoder(res.Body).Decode(&reply)
+	return reply, err
+}
+
+// Close 停止所有续约goroutine. 不会注销服务, 需要时先调用Deregister.
+func (c *Client) Close() {
+	c.keep.stopAll()
+}
+
 // 更新 Provider的http逻辑
-type serviceUpdateHandler struct{}
+type serviceUpdateHandler struct {
+	prov     *providers
+	brk      *breakers
+	outliers *outlierDetector
+}
 
 func (s *serviceUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -101,54 +296,261 @@ func (s *serviceUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	fmt.Printf("接收到服务更新通知: %+v\n", p)
-	prov.Update(p)
+	// 已经下线的实例不再需要熔断和异常检测的状态. 过期的patch不会生效, 也不会清除状态
+	for _, entry := range s.prov.Update(p) {
+		s.brk.forget(entry.URL)
+		s.outliers.forget(entry.Name, entry.URL)
+	}
 }
 
 type providers struct {
-	services map[ServiceName][]string
-	mutex    *sync.RWMutex
+	services  map[ServiceName][]Instance
+	balancers map[ServiceName]Balancer // 每个依赖服务的负载均衡策略, 没有设置时使用随机策略
+	mutex     *sync.RWMutex
+	// changed 实例列表变化时关闭并替换, 用于WaitForProvider等待; subscribers 订阅了实例变化的订阅者
+	changed     chan struct{}
+	subscribers map[ServiceName]map[*subscription]bool
+	// 丢弃过期的patch: epoch 注册中心的纪元ID, floor 最近一次快照的版本号, 不大于它的增量patch已经包含在快照中;
+	// applied 每个实例URL最近一次生效的增量patch, 同一个实例更旧的patch不再生效. 收到新的快照时清理.
+	epoch   string
+	floor   uint64
+	applied map[string]appliedPatch
+}
+
+// appliedPatch 一个实例最近一次生效的增量patch
+type appliedPatch struct {
+	entry   patchEntry
+	seq     uint64
+	removed bool
 }
 
-func (p *providers) Update(pat patch) {
+// Update 根据patch更新provider, 返回生效的删除条目. 实例列表按写时复制的方式修改, 已经交给Balancer的列表不会被改动.
+// 更新后把实例的增减通知给订阅者.
+//
+// 注册中心为每个patch启动一个goroutine发送, 快照和增量patch到达的顺序可能和生成的顺序不同.
+// 根据patch的纪元ID和版本号: 比快照旧的增量patch、比同一个实例已经生效的patch旧的增量patch、注册中心重启之前的patch都被丢弃;
+// 比快照新、先到达的增量patch在快照之后重新应用.
+func (p *providers) Update(pat patch) []patchEntry {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	for _, entry := range pat.Added {
-		if _, ok := p.services[entry.Name]; !ok {
-			// 如果服务还不存在, 先创建一个空的切片.
-			p.services[entry.Name] = []string{}
+	if pat.Epoch != "" && pat.Epoch != p.epoch {
+		if p.epoch != "" && !epochAfter(pat.Epoch, p.epoch) {
+			// 重启之前的注册中心发出的patch迟到了
+			return nil
 		}
-		p.services[entry.Name] = append(p.services[entry.Name], entry.URL)
+		// 注册中心重启过, 之前的版本号不再有意义
+		p.epoch, p.floor = pat.Epoch, 0
+		clear(p.applied)
 	}
-
-	// 遍历通知的移除服务列表, 如果存在, 则遍历Provider找到对应的URL并移除.
-	for _, entry := range pat.Removed {
-		if providedUrls, ok := p.services[entry.Name]; ok {
-			for i, providedUrl := range providedUrls {
-				if providedUrl == entry.URL {
-					p.services[entry.Name] = append(providedUrls[:i], providedUrls[i+1:]...)
-				}
+	added, removed := pat.Added, pat.Removed
+	if pat.Snapshot {
+		if pat.Seq < p.floor {
+			return nil
+		}
+		added, removed = slices.Clone(added), nil
+		for url, a := range p.applied {
+			if a.seq <= pat.Seq {
+				delete(p.applied, url)
+			} else if a.removed {
+				removed = append(removed, a.entry)
+			} else {
+				added = append(added, a.entry)
 			}
 		}
+		p.floor = pat.Seq
+	} else if pat.Seq > 0 {
+		added = p.fresh(added, pat.Seq, false)
+		removed = p.fresh(removed, pat.Seq, true)
+	}
+
+	before := p.services
+	p.services = maps.Clone(p.services)
+	if pat.Snapshot {
+		// 完整快照: 丢弃旧的provider, 以注册中心当前的信息为准.
+		p.services = make(map[ServiceName][]Instance)
 	}
+	for _, entry := range added {
+		instances := p.services[entry.Name]
+		i := slices.IndexFunc(instances, func(ins Instance) bool { return ins.URL == entry.URL })
+		if i >= 0 && instances[i].Weight == entry.Weight {
+			continue
+		}
+		instances = slices.Clone(instances)
+		if i >= 0 {
+			// 已经存在的实例只更新权重
+			instances[i].Weight = entry.Weight
+		} else {
+			instances = append(instances, Instance{URL: entry.URL, Weight: entry.Weight})
+		}
+		p.services[entry.Name] = instances
+	}
+
+	// 遍历通知的移除服务列表, 如果存在, 则从Provider中移除对应的URL.
+	for _, entry := range removed {
+		if instances, ok := p.services[entry.Name]; ok {
+			p.services[entry.Name] = slices.DeleteFunc(slices.Clone(instances), func(ins Instance) bool {
+				return ins.URL == entry.URL
+			})
+		}
+	}
+	for name, b := range p.balancers {
+		if pruner, ok := b.(Pruner); ok && !slices.Equal(before[name], p.services[name]) {
+			pruner.Prune(p.services[name])
+		}
+	}
+	p.publish(before)
+	return removed
 }
 
-// get 根据服务名获取对应的服务提供者URL列表. 如果存在多个url可以使用, 则可以使用负载均衡策略选择一个.
-// 这里简单起见, 直接随机返回一个URL.
-func (p *providers) get(name ServiceName) (string, error) {
-	providers, ok := p.services[name]
-	if !ok {
-		return "", fmt.Errorf("服务不存在: %s", name)
+// fresh 返回增量patch中没有过期的条目, 并记录它们的版本号. 调用方需要持有锁.
+func (p *providers) fresh(entries []patchEntry, seq uint64, removed bool) []patchEntry {
+	var result []patchEntry
+	for _, e := range entries {
+		if seq <= p.floor {
+			continue
+		}
+		if a, ok := p.applied[e.URL]; ok && a.seq > seq {
+			continue
+		}
+		p.applied[e.URL] = appliedPatch{entry: e, seq: seq, removed: removed}
+		result = append(result, e)
 	}
-	// 生成一个在[0.0, len(providers))范围内的随机浮点数, 然后转换为整数索引.
-	idx := int(rand.Float32() * float32(len(providers)))
-	return providers[idx], nil
+	return result
 }
 
-var prov = providers{
-	services: make(map[ServiceName][]string),
-	mutex:    &sync.RWMutex{},
+// epochAfter 判断纪元ID a是否比b新. 纪元ID是注册中心启动时间的36进制纳秒数; 无法解析时认为不同的纪元ID更新.
+func epochAfter(a, b string) bool {
+	x, errA := strconv.ParseInt(a, 36, 64)
+	y, errB := strconv.ParseInt(b, 36, 64)
+	if errA != nil || errB != nil {
+		return true
+	}
+	return x > y
+}
+
+func (p *providers) setBalancer(name ServiceName, b Balancer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.balancers[name] = b
+}
+
+// get 根据服务名获取对应的服务提供者URL. 存在多个url可以使用时, 由该服务的负载均衡策略选择一个, 熔断中的实例会被跳过.
+// 返回的done必须在请求结束后调用, 最少未完成请求等策略依赖它统计每个实例的请求数量.
+// report为true时调用方会通过done上报调用结果, 熔断器据此统计; 否则熔断器只用来跳过熔断中的实例.
+// exclude中的实例不参与选择, 用于换实例重试.
+func (c *Client) get(name ServiceName, key string, report bool, exclude map[string]bool) (string, func(error), error) {
+	c.prov.mutex.RLock()
+	instances, ok := c.prov.services[name]
+	balancer := c.prov.balancers[name]
+	c.prov.mutex.RUnlock()
+	total := len(instances)
+	if len(exclude) > 0 {
+		instances = slices.DeleteFunc(slices.Clone(instances), func(ins Instance) bool { return exclude[ins.URL] })
+	}
+	if !ok || len(instances) == 0 {
+		return "", nil, fmt.Errorf("服务不存在: %s", name)
+	}
+	if balancer == nil {
+		balancer = random{}
+	}
+	ins, err := c.brk.choose(name, instances, report, func(instances []Instance) (Instance, error) {
+		if available := c.outliers.filter(name, instances); len(available) > 0 {
+			// 被摘除的实例数量有比例限制, 正常情况下总有可用的实例; 万一全部被摘除, 仍然从中选择
+			instances = available
+		}
+		ins, err := balancer.Pick(instances, key)
+		if err != nil {
+			return Instance{}, fmt.Errorf("服务%s选择实例失败: %w", name, err)
+		}
+		return ins, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	done := func(err error) {
+		if report {
+			c.brk.record(name, ins.URL, err)
+			c.outliers.record(name, ins.URL, err, total)
+		}
+		if r, ok := balancer.(Releaser); ok {
+			r.Release(ins.URL)
+		}
+	}
+	return ins.URL, done, nil
+}
+
+// GetProvider 获取依赖服务的一个实例URL. 适合不需要统计请求结束的场景, 需要时使用PickProvider.
+// 选出实例后立即结束, 所以最少未完成请求和power-of-two策略看不到这些请求的负载.
+// 熔断中的实例会被跳过, 但调用结果需要调用方通过Report上报, 否则熔断器和异常检测不会因为这些请求的失败而生效.
+func (c *Client) GetProvider(name ServiceName) (string, error) {
+	url, done, err := c.get(name, "", false, nil)
+	if err != nil {
+		return "", err
+	}
+	done(nil)
+	return url, nil
+}
+
+// HasProvider 依赖服务是否有注册中心推送的实例. 只读取实例列表, 不经过负载均衡和熔断器, 不会影响它们的状态;
+// 实例全部熔断时仍然返回true, 熔断是暂时的, 不代表依赖服务不存在.
+func (c *Client) HasProvider(name ServiceName) bool {
+	c.prov.mutex.RLock()
+	defer c.prov.mutex.RUnlock()
+	return len(c.prov.services[name]) > 0
+}
+
+// Report 上报一次对GetProvider返回的实例的调用结果(成功时err为nil), 熔断器和异常检测据此统计.
+// PickProvider和Transport的调用结果通过done上报, 不需要再调用Report.
+func (c *Client) Report(name ServiceName, url string, err error) {
+	c.prov.mutex.RLock()
+	total := len(c.prov.services[name])
+	c.prov.mutex.RUnlock()
+	c.brk.record(name, url, err)
+	c.outliers.record(name, url, err, total)
+}
+
+// PickProvider 按路由键key选择依赖服务的一个实例URL, key用于一致性哈希策略, 其他策略可以传空字符串.
+// 请求结束后必须调用done, 并传入请求的错误(成功时为nil), 熔断器根据它判断实例是否可用.
+func (c *Client) PickProvider(name ServiceName, key string) (url string, done func(err error), err error) {
+	return c.get(name, key, true, nil)
+}
+
+// SetBalancer 为依赖服务设置自定义的负载均衡策略
+func (c *Client) SetBalancer(name ServiceName, b Balancer) {
+	c.prov.setBalancer(name, b)
+}
+
+// 下面是使用默认Client的包级函数, 保持原来的用法
+
+var defaultClient = NewClient(ClientOptions{Mux: http.DefaultServeMux, HTTPClient: http.DefaultClient})
+
+// DefaultClient 返回包级函数使用的默认客户端, 它的handler添加在http.DefaultServeMux上
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// RegisterService 使用默认客户端注册服务
+func RegisterService(re RegistrationEntry) error {
+	return defaultClient.Register(re)
+}
+
+// DeregisterService 使用默认客户端注销服务
+func DeregisterService(re RegistrationEntry) error {
+	return defaultClient.Deregister(re)
 }
 
 func GetProvider(name ServiceName) (string, error) {
-	return prov.get(name)
+	return defaultClient.GetProvider(name)
+}
+
+func Report(name ServiceName, url string, err error) {
+	defaultClient.Report(name, url, err)
+}
+
+func PickProvider(name ServiceName, key string) (url string, done func(err error), err error) {
+	return defaultClient.PickProvider(name, key)
+}
+
+func SetBalancer(name ServiceName, b Balancer) {
+	defaultClient.SetBalancer(name, b)
 }
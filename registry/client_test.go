@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestPatchOrdering patch到达的顺序和生成的顺序不同时, 过期的patch被丢弃
+func TestPatchOrdering(t *testing.T) {
+	a := patchEntry{Name: "Svc", URL: "http://a", Weight: 1}
+	b := patchEntry{Name: "Svc", URL: "http://b", Weight: 1}
+	add := func(epoch string, seq uint64, entries ...patchEntry) patch {
+		return patch{Added: entries, Epoch: epoch, Seq: seq}
+	}
+	remove := func(epoch string, seq uint64, entries ...patchEntry) patch {
+		return patch{Removed: entries, Epoch: epoch, Seq: seq}
+	}
+	snapshot := func(epoch string, seq uint64, entries ...patchEntry) patch {
+		return patch{Added: entries, Snapshot: true, Epoch: epoch, Seq: seq}
+	}
+	tests := []struct {
+		name    string
+		patches []patch
+		want    []string
+	}{
+		{"按顺序到达", []patch{add("e1", 1, a), add("e1", 2, b), remove("e1", 3, a)}, []string{"http://b"}},
+		{"删除先于添加到达", []patch{remove("e1", 7, a), add("e1", 6, a)}, nil},
+		{"不同实例乱序", []patch{add("e1", 5, b), add("e1", 4, a)}, []string{"http://b", "http://a"}},
+		{"快照之后到达的旧patch", []patch{snapshot("e1", 5, a), remove("e1", 4, a)}, []string{"http://a"}},
+		{"旧快照之后到达", []patch{add("e1", 6, b), snapshot("e1", 5, a)}, []string{"http://a", "http://b"}},
+		{"快照之前到达的删除", []patch{add("e1", 1, a), remove("e1", 6, a), snapshot("e1", 5, a)}, nil},
+		{"旧快照", []patch{snapshot("e1", 6, b), snapshot("e1", 5, a)}, []string{"http://b"}},
+		{"重启之前的patch", []patch{snapshot("2", 1, a), add("1", 9, b)}, []string{"http://a"}},
+		{"重启之后版本号重新开始", []patch{add("1", 9, a), snapshot("2", 1, b)}, []string{"http://b"}},
+		{"没有版本号的旧注册中心", []patch{remove("", 0, a), add("", 0, a)}, []string{"http://a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewClient(ClientOptions{RegistryAddr: "http://registry"}).prov
+			for _, pat := range tt.patches {
+				p.Update(pat)
+			}
+			var got []string
+			for _, ins := range p.services["Svc"] {
+				got = append(got, ins.URL)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("实例为%v, 期望%v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("实例为%v, 期望%v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestClientWatch Watch在注册列表变化时返回, ctx取消时立即返回
+func TestClientWatch(t *testing.T) {
+	_, reg := newTestRegistry(t)
+	c := NewClient(ClientOptions{RegistryAddr: reg.URL})
+	if err := c.Register(newTestService(t, c, "Consumer")); err != nil {
+		t.Fatal(err)
+	}
+	// 注册列表已经不是初始的版本0, 立即返回
+	reply, err := c.Watch(context.Background(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan ListReply, 1)
+	go func() {
+		reply, _ := c.Watch(context.Background(), "", reply.Version)
+		done <- reply
+	}()
+	p := NewClient(ClientOptions{RegistryAddr: reg.URL})
+	pe := newTestService(t, p, "Provider")
+	if err := p.Register(pe); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-done:
+		if len(got.Services) != 2 || got.Services[1].ServiceURL != pe.ServiceURL {
+			t.Fatalf("注册后Watch返回%+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("注册列表变化后Watch没有返回")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Watch(ctx, "", reply.Version+1); err == nil {
+		t.Fatal("ctx取消后Watch应该返回错误")
+	}
+}
+
+// TestHasProvider HasProvider只看实例列表, 实例全部熔断时仍然返回true, 也不会占用半开熔断器的试探机会
+func TestHasProvider(t *testing.T) {
+	c := NewClient(ClientOptions{RegistryAddr: "http://registry"})
+	if c.HasProvider("Svc") {
+		t.Fatal("没有实例时应该返回false")
+	}
+	c.prov.Update(patch{Added: []patchEntry{{Name: "Svc", URL: "http://a"}}})
+	openBreaker(c.brk, "http://a")
+	if _, err := c.GetProvider("Svc"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("实例熔断后GetProvider应该返回ErrCircuitOpen, 得到%v", err)
+	}
+	if !c.HasProvider("Svc") {
+		t.Fatal("实例全部熔断时HasProvider应该返回true")
+	}
+	expire(c.brk, "http://a")
+	for range 3 {
+		c.HasProvider("Svc")
+	}
+	if _, err := c.GetProvider("Svc"); err != nil {
+		t.Fatalf("HasProvider不应该占用半开熔断器的试探机会, 得到%v", err)
+	}
+}
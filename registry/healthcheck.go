@@ -0,0 +1,281 @@
+package registry
+
+import (
+	"DistributedGo/tracing"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 健康检查调度器. 每个服务实例有自己的定时器, 到期后把实例放进队列, 由固定数量的worker执行检查:
+// 1. 定时器带随机抖动, 避免所有实例在同一时刻被检查;
+// 2. worker数量固定, 并发的检查请求数量有上限. 队列没有上限, 定时器的回调只把实例放进队列,
+//    不会因为worker忙而阻塞, 实例再多也不会堆积定时器的goroutine;
+// 3. 每次检查都带超时, 一个卡住的服务不会拖慢其他服务的检查;
+// 4. 检查只使用实例信息的副本, 不会在没有锁的情况下遍历注册列表.
+// 检查逻辑与之前一致: 失败后立即下线并按间隔重试, 重试期间恢复则重新注册, 重试次数用完后放弃该实例.
+// 实例返回HealthReport时, warning级别的实例仍然可用, 但会以注册中心的名义记录一个健康信号.
+
+const (
+	healthCheckFreq     = 3 * time.Second
+	healthCheckJitter   = 0.2 // 定时器在检查周期上下浮动的比例
+	healthCheckTimeout  = 2 * time.Second
+	healthCheckRetry    = 1 * time.Second
+	healthCheckAttempts = 3
+	healthCheckWorkers  = 64
+
+	registryReporter = RegistryServiceName // 注册中心记录健康信号时的上报者
+)
+
+type healthTarget struct {
+	entry    RegistrationEntry
+	failures int  // 连续失败次数
+	down     bool // 是否已经因为检查失败从注册列表中删除
+	timer    healthTimer
+}
+
+// healthTimer 实例的检查定时器, *time.Timer实现了它
+type healthTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type healthScheduler struct {
+	targets map[string]*healthTarget
+	mutex   *sync.Mutex
+	queue   []string      // 到期需要检查的实例
+	notify  chan struct{} // 队列中有新的实例, 容量和worker数量相同
+	client  *http.Client
+	workers int
+	signal  func(HealthSignal) // 记录warning级别实例的健康信号
+	done    chan struct{}      // 关闭后worker和定时器都停止
+	stopped bool
+	// afterFunc 创建检查定时器, check 执行一次检查, 默认为time.AfterFunc和probe. 测试中替换成手动推进的时钟和假的检查
+	afterFunc func(d time.Duration, f func()) healthTimer
+	check     func(RegistrationEntry) (HealthReport, error)
+}
+
+func newHealthScheduler(client *http.Client, workers int) *healthScheduler {
+	h := &healthScheduler{
+		targets: make(map[string]*healthTarget),
+		mutex:   &sync.Mutex{},
+		notify:  make(chan struct{}, workers),
+		client:  client,
+		workers: workers,
+		done:    make(chan struct{}),
+		afterFunc: func(d time.Duration, f func()) healthTimer {
+			return time.AfterFunc(d, f)
+		},
+	}
+	h.check = h.probe
+	return h
+}
+
+func (h *healthScheduler) start(r *registry) {
+	for i := 0; i < h.workers; i++ {
+		go h.work(r)
+	}
+}
+
+// stop 停止所有worker和定时器, 之后不再进行健康检查.
+func (h *healthScheduler) stop() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.stopped {
+		return
+	}
+	h.stopped = true
+	h.queue = nil
+	close(h.done)
+	for key, t := range h.targets {
+		t.timer.Stop()
+		delete(h.targets, key)
+	}
+}
+
+// enqueue 定时器到期时调用, 调度器停止后直接丢弃
+func (h *healthScheduler) enqueue(key string) {
+	h.mutex.Lock()
+	if h.stopped {
+		h.mutex.Unlock()
+		return
+	}
+	h.queue = append(h.queue, key)
+	h.mutex.Unlock()
+	select {
+	case h.notify <- struct{}{}:
+	default:
+		// 已经有足够的通知唤醒所有worker
+	}
+}
+
+// next 取出队列中的下一个实例, 队列为空时等待, 调度器停止后返回false
+func (h *healthScheduler) next() (string, bool) {
+	for {
+		h.mutex.Lock()
+		if len(h.queue) > 0 {
+			key := h.queue[0]
+			h.queue = h.queue[1:]
+			h.mutex.Unlock()
+			return key, true
+		}
+		h.mutex.Unlock()
+		select {
+		case <-h.notify:
+		case <-h.done:
+			return "", false
+		}
+	}
+}
+
+// track 开始对实例做健康检查. 已经在检查的实例不会重复添加, 只更新注册信息, 之后的检查使用新的HeartbeatURL.
+func (h *healthScheduler) track(re RegistrationEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := keyOf(re)
+	if h.stopped {
+		return
+	}
+	if t, ok := h.targets[key]; ok {
+		t.entry = re
+		return
+	}
+	t := &healthTarget{entry: re}
+	h.targets[key] = t
+	t.timer = h.afterFunc(jitter(healthCheckFreq), func() { h.enqueue(key) })
+}
+
+// untrack 停止对实例的健康检查. 已经进入队列的检查会在worker中被丢弃.
+func (h *healthScheduler) untrack(re RegistrationEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := keyOf(re)
+	if t, ok := h.targets[key]; ok {
+		t.timer.Stop()
+		delete(h.targets, key)
+	}
+}
+
+func (h *healthScheduler) work(r *registry) {
+	for {
+		key, ok := h.next()
+		if !ok {
+			return
+		}
+		h.mutex.Lock()
+		t, ok := h.targets[key]
+		var re RegistrationEntry
+		if ok {
+			re = t.entry
+		}
+		h.mutex.Unlock()
+		if !ok {
+			continue
+		}
+		report, err := h.check(re)
+		if err == nil && report.Status == HealthWarning && h.signal != nil {
+			h.signal(HealthSignal{
+				ServiceName: re.ServiceName,
+				ServiceURL:  re.ServiceURL,
+				Level:       HealthWarning,
+				Reporter:    registryReporter,
+				Reason:      report.Failed(),
+				Expires:     time.Now().Add(2 * healthCheckFreq),
+			})
+		}
+		h.handle(r, key, re, err)
+	}
+}
+
+// probe 请求一次健康检查接口, 超时或者返回非200都算失败.
+// 接口返回HealthReport时一并解析, 只返回状态码的旧接口得到一个空的报告.
+func (h *healthScheduler) probe(re RegistrationEntry) (_ HealthReport, err error) {
+	var report HealthReport
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, string(registryReporter), "heartbeat "+string(re.ServiceName), tracing.Client)
+	span.SetAttribute("instance", re.ServiceURL)
+	defer func() { span.End(err) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, re.HeartbeatURL, nil)
+	if err != nil {
+		return report, err
+	}
+	tracing.Inject(ctx, req.Header)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return report, err
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&report)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return report, &heartbeatStatusError{code: resp.StatusCode, reason: report.Failed()}
+	}
+	return report, nil
+}
+
+// handle 根据检查结果更新实例状态并安排下一次检查. 注册列表的修改在调度器的锁之外进行.
+func (h *healthScheduler) handle(r *registry, key string, re RegistrationEntry, err error) {
+	h.mutex.Lock()
+	t, ok := h.targets[key]
+	if !ok {
+		// 检查期间实例被注销了
+		h.mutex.Unlock()
+		return
+	}
+	var recovered, failed bool
+	if err == nil {
+		recovered = t.down
+		t.failures, t.down = 0, false
+		t.timer.Reset(jitter(healthCheckFreq))
+	} else {
+		t.failures++
+		failed = !t.down
+		t.down = true
+		log.Printf("Heartbeat attempt %d failed for service: %s, error: %v\n", t.failures, re.ServiceName, err)
+		if t.failures >= healthCheckAttempts {
+			// 重试次数用完, 放弃该实例, 等待它重新注册
+			log.Printf("Service %s at %s is gone after %d attempts.\n", re.ServiceName, re.ServiceURL, t.failures)
+			delete(h.targets, key)
+		} else {
+			t.timer.Reset(healthCheckRetry)
+		}
+	}
+	h.mutex.Unlock()
+
+	if recovered {
+		// 服务恢复了, 重新注册
+		log.Printf("Service %s has recovered. Re-registering.\n", re.ServiceName)
+		if err := r.addService(re); err != nil {
+			log.Printf("Failed to re-register service %s: %v\n", re.ServiceName, err)
+		}
+	}
+	if failed {
+		// 第一次失败, 先下线
+		if err := r.dropService(re); err != nil {
+			log.Printf("Failed to remove service %s: %v\n", re.ServiceName, err)
+		}
+	}
+}
+
+// jitter 在d的基础上随机浮动healthCheckJitter比例的时间
+func jitter(d time.Duration) time.Duration {
+	delta := (rand.Float64()*2 - 1) * healthCheckJitter * float64(d)
+	return d + time.Duration(delta)
+}
+
+type heartbeatStatusError struct {
+	code   int
+	reason string // 健康检查报告中没有通过的检查
+}
+
+func (e *heartbeatStatusError) Error() string {
+	if e.reason != "" {
+		return "unexpected heartbeat status " + http.StatusText(e.code) + ": " + e.reason
+	}
+	return "unexpected heartbeat status " + http.StatusText(e.code)
+}
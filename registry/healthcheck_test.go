@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTimers 手动推进的时钟, 到期的定时器在advance中同步触发
+type fakeTimers struct {
+	now    time.Duration
+	timers []*fakeTimer
+	mutex  *sync.Mutex
+}
+
+type fakeTimer struct {
+	clock  *fakeTimers
+	at     time.Duration
+	f      func()
+	active bool
+}
+
+func newFakeTimers() *fakeTimers {
+	return &fakeTimers{mutex: &sync.Mutex{}}
+}
+
+func (c *fakeTimers) afterFunc(d time.Duration, f func()) healthTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, at: c.now + d, f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// advance 推进时钟, 触发到期的定时器
+func (c *fakeTimers) advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now += d
+	var due []func()
+	for _, t := range c.timers {
+		if t.active && t.at <= c.now {
+			t.active = false
+			due = append(due, t.f)
+		}
+	}
+	c.mutex.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
+
+// pending 返回还没有到期的定时器数量
+func (c *fakeTimers) pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := t.active
+	t.at, t.active = t.clock.now+d, true
+	return active
+}
+
+// okTransport 对所有请求返回200, 注册中心推送patch时使用
+type okTransport struct{}
+
+func (okTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+// waitFor 等待cond成立. 只用来避免测试永远挂起, 不对耗时做断言
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestHealthSchedulerLoad 大量实例通过addService注册, 每个检查周期内每个实例恰好被检查一次,
+// 同时进行的检查不超过worker数量, goroutine的数量不随实例数量增长.
+func TestHealthSchedulerLoad(t *testing.T) {
+	const n, workers = 2000, 8
+	clock := newFakeTimers()
+	var (
+		mutex      sync.Mutex
+		probes     = make(map[string]int, n)
+		total      atomic.Int64
+		inflight   atomic.Int64
+		maxWorkers atomic.Int64
+		maxRoutine atomic.Int64
+	)
+	s := NewServer(ServerOptions{HTTPClient: &http.Client{Transport: okTransport{}}, HealthCheckWorkers: workers})
+	defer func() { _ = s.Close() }()
+	s.health.afterFunc = clock.afterFunc
+	s.health.check = func(re RegistrationEntry) (HealthReport, error) {
+		cur := inflight.Add(1)
+		defer inflight.Add(-1)
+		for m := maxWorkers.Load(); cur > m && !maxWorkers.CompareAndSwap(m, cur); m = maxWorkers.Load() {
+		}
+		for g, m := int64(runtime.NumGoroutine()), maxRoutine.Load(); g > m && !maxRoutine.CompareAndSwap(m, g); m = maxRoutine.Load() {
+		}
+		mutex.Lock()
+		probes[re.ServiceURL]++
+		mutex.Unlock()
+		runtime.Gosched() // 让其他worker有机会同时检查
+		total.Add(1)
+		return HealthReport{Status: HealthPassing}, nil
+	}
+	s.StartHealthCheck()
+
+	for i := range n {
+		url := fmt.Sprintf("http://instance-%d", i)
+		err := s.reg.addService(RegistrationEntry{
+			ServiceName:      "LoadService",
+			ServiceURL:       url,
+			ServiceUpdateURL: url + "/services",
+			HeartbeatURL:     url + "/health",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	baseline := int64(runtime.NumGoroutine())
+
+	// 定时器最晚在一个周期加上抖动后到期
+	period := time.Duration(float64(healthCheckFreq) * (1 + healthCheckJitter))
+	for round := 1; round <= 2; round++ {
+		waitFor(t, "所有实例安排好下一次检查", func() bool { return clock.pending() == n })
+		clock.advance(period)
+		waitFor(t, "所有实例被检查", func() bool { return total.Load() == int64(round*n) })
+		mutex.Lock()
+		for url, count := range probes {
+			if count != round {
+				t.Fatalf("第%d个周期%s被检查了%d次", round, url, count)
+			}
+		}
+		mutex.Unlock()
+	}
+
+	if got := len(probes); got != n {
+		t.Fatalf("检查了%d个实例, 期望%d个", got, n)
+	}
+	if got := maxWorkers.Load(); got > workers {
+		t.Fatalf("同时进行了%d个检查, worker只有%d个", got, workers)
+	}
+	s.health.mutex.Lock()
+	tracked, down := len(s.health.targets), 0
+	for _, target := range s.health.targets {
+		if target.down {
+			down++
+		}
+	}
+	s.health.mutex.Unlock()
+	if tracked != n || down != 0 {
+		t.Fatalf("检查后有%d个实例在调度器中, %d个被下线", tracked, down)
+	}
+	// worker的数量是固定的, 到期的定时器只把实例放进队列, 不会各自阻塞一个goroutine
+	if extra := maxRoutine.Load() - baseline; extra > workers {
+		t.Fatalf("检查期间多出了%d个goroutine", extra)
+	}
+}
@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 注册中心的注册信息只保存在内存中, 注册中心重启后所有服务都会丢失.
+// 服务注册成功后, 客户端在后台定期续约, 通过下面三种信号判断注册中心是否丢失了自己:
+// 1. 续约失败: 注册中心不可达, 或者返回404表示找不到本服务;
+// 2. 纪元ID变化: 注册中心重启过;
+// 3. 心跳缺失: 注册中心很久没有调用本服务的健康检查接口.
+// 发现丢失后按指数退避重新注册, 重新注册时注册中心会发送依赖服务的完整快照, 重新加载providers.
+
+const (
+	renewInterval       = 5 * time.Second
+	heartbeatTimeout    = 15 * time.Second // 注册中心健康检查周期为3秒, 超过这个时间没有心跳则认为注册中心丢失了本服务
+	minReregisterPeriod = 1 * time.Second
+	maxReregisterPeriod = 30 * time.Second
+)
+
+var errNotRegistered = errors.New("服务未在注册中心注册")
+
+type keeper struct {
+	// 续约周期和心跳超时, 默认为renewInterval和heartbeatTimeout. 测试中可以缩短
+	renewInterval    time.Duration
+	heartbeatTimeout time.Duration
+	epoch            string
+	lastHeartbeat    time.Time
+	stops            map[string]chan struct{}     // 每个注册的服务实例对应一个续约goroutine的停止信号
+	entries          map[string]RegistrationEntry // 每个服务实例最近一次注册的信息, 续约和重新注册时使用
+	mutex            *sync.Mutex
+}
+
+func newKeeper() *keeper {
+	return &keeper{
+		renewInterval:    renewInterval,
+		heartbeatTimeout: heartbeatTimeout,
+		stops:            make(map[string]chan struct{}),
+		entries:          make(map[string]RegistrationEntry),
+		mutex:            &sync.Mutex{},
+	}
+}
+
+func keyOf(re RegistrationEntry) string {
+	return string(re.ServiceName) + "@" + re.ServiceURL
+}
+
+func (k *keeper) setEpoch(e string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.epoch = e
+}
+
+// touch 记录最近一次收到注册中心消息(心跳或注册成功)的时间
+func (k *keeper) touch() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.lastHeartbeat = time.Now()
+}
+
+// start 为服务实例启动续约goroutine, 同一个实例只会启动一次. 再次注册时只更新注册信息, 例如增加了依赖的服务.
+func (k *keeper) start(re RegistrationEntry, keepAlive func(RegistrationEntry, <-chan struct{})) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	key := keyOf(re)
+	k.entries[key] = re
+	if _, ok := k.stops[key]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	k.stops[key] = stop
+	go keepAlive(re, stop)
+}
+
+func (k *keeper) stop(re RegistrationEntry) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	key := keyOf(re)
+	if stop, ok := k.stops[key]; ok {
+		close(stop)
+		delete(k.stops, key)
+	}
+	delete(k.entries, key)
+}
+
+// latest 返回服务实例最近一次注册的信息
+func (k *keeper) latest(re RegistrationEntry) RegistrationEntry {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if e, ok := k.entries[keyOf(re)]; ok {
+		return e
+	}
+	return re
+}
+
+func (k *keeper) stopAll() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for key, stop := range k.stops {
+		close(stop)
+		delete(k.stops, key)
+	}
+	clear(k.entries)
+}
+
+func (c *Client) keepAlive(re RegistrationEntry, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.keep.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		re := c.keep.latest(re)
+		if err := c.check(re); err != nil {
+			log.Printf("注册中心丢失了服务[%s]: %v, 开始重新注册\n", re.ServiceName, err)
+			c.reregister(re, stop)
+		}
+	}
+}
+
+// check 续约并检查三种丢失信号, 返回nil表示注册状态正常.
+func (c *Client) check(re RegistrationEntry) error {
+	e, err := c.renew(re)
+	if err != nil {
+		return err
+	}
+	k := c.keep
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.epoch != "" && e != k.epoch {
+		return fmt.Errorf("注册中心纪元ID变化: %s -> %s", k.epoch, e)
+	}
+	if since := time.Since(k.lastHeartbeat); since > k.heartbeatTimeout {
+		return fmt.Errorf("已经%v没有收到注册中心的心跳", since.Round(time.Second))
+	}
+	return nil
+}
+
+// reregister 按指数退避重新注册, 直到成功或者服务主动注销.
+func (c *Client) reregister(re RegistrationEntry, stop <-chan struct{}) {
+	backoff := minReregisterPeriod
+	for {
+		err := c.register(re)
+		if err == nil {
+			log.Printf("服务[%s]重新注册成功\n", re.ServiceName)
+			return
+		}
+		log.Printf("服务[%s]重新注册失败: %v, %v后重试\n", re.ServiceName, err, backoff)
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReregisterPeriod)
+	}
+}
+
+// renew 向注册中心发送续约请求, 返回注册中心的纪元ID.
+func (c *Client) renew(re RegistrationEntry) (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buffer).Encode(re); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.servicesURL, buffer)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("关闭服务续约响应Body失败, %s:%s\n", re.ServiceName, re.ServiceURL)
+		}
+	}(res.Body)
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Header.Get(EpochHeader), nil
+	case http.StatusNotFound:
+		return "", errNotRegistered
+	default:
+		return "", fmt.Errorf("服务续约失败, 状态码: %d, 服务: %s:%s", res.StatusCode, re.ServiceName, re.ServiceURL)
+	}
+}
+
+// reportSignal 向注册中心上报健康信号
+func (c *Client) reportSignal(sig HealthSignal) error {
+	buffer := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buffer).Encode(sig); err != nil {
+		return err
+	}
+	res, err := c.httpClient.Post(c.signalsURL, "application/json", buffer)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("上报健康信号失败, 状态码: %d", res.StatusCode)
+	}
+	return nil
+}
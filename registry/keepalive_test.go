@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// restartableRegistry 地址不变、可以重启的注册中心. 重启后是一个新的Server, 纪元ID不同, 注册列表为空.
+type restartableRegistry struct {
+	server   *Server
+	register int // 收到的注册请求数量
+	mutex    *sync.Mutex
+}
+
+func newRestartableRegistry(t *testing.T) (*restartableRegistry, *httptest.Server) {
+	t.Helper()
+	rr := &restartableRegistry{server: NewServer(ServerOptions{}), mutex: &sync.Mutex{}}
+	srv := httptest.NewServer(rr)
+	t.Cleanup(func() {
+		srv.Close()
+		_ = rr.server.Close()
+	})
+	return rr, srv
+}
+
+func (rr *restartableRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mutex.Lock()
+	s := rr.server
+	if r.Method == http.MethodPost && r.URL.Path == "/services" {
+		rr.register++
+	}
+	rr.mutex.Unlock()
+	s.Handler().ServeHTTP(w, r)
+}
+
+// restart 换成新的Server, 返回它
+func (rr *restartableRegistry) restart() *Server {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	// 纪元ID是纳秒时间戳, 保证新的纪元ID更大
+	time.Sleep(time.Millisecond)
+	_ = rr.server.Close()
+	rr.server = NewServer(ServerOptions{})
+	return rr.server
+}
+
+func (rr *restartableRegistry) registrations() int {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return rr.register
+}
+
+// eventually 在1秒内等待cond成立
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("1秒内没有%s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fastClient 续约周期很短的客户端
+func fastClient(addr string, heartbeatTimeout time.Duration) *Client {
+	c := NewClient(ClientOptions{RegistryAddr: addr})
+	c.keep.renewInterval = 20 * time.Millisecond
+	c.keep.heartbeatTimeout = heartbeatTimeout
+	return c
+}
+
+func TestReregister(t *testing.T) {
+	tests := []struct {
+		name             string
+		heartbeatTimeout time.Duration
+		// lose 让注册中心丢失或者看起来丢失了服务
+		lose func(rr *restartableRegistry, re RegistrationEntry)
+	}{
+		{
+			"重启后续约返回404",
+			time.Hour,
+			func(rr *restartableRegistry, _ RegistrationEntry) { rr.restart() },
+		},
+		{
+			"重启后纪元ID变化",
+			time.Hour,
+			func(rr *restartableRegistry, re RegistrationEntry) {
+				// 新的注册中心已经有这个服务(例如从别的途径恢复), 续约成功但纪元ID不同
+				_ = rr.restart().reg.addService(re)
+			},
+		},
+		{
+			"心跳缺失",
+			100 * time.Millisecond, // 测试中的注册中心不做健康检查, 不会有心跳
+			func(*restartableRegistry, RegistrationEntry) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, reg := newRestartableRegistry(t)
+			c := fastClient(reg.URL, tt.heartbeatTimeout)
+			re := newTestService(t, c, "Svc")
+			if err := c.Register(re); err != nil {
+				t.Fatal(err)
+			}
+			tt.lose(rr, re)
+			eventually(t, "重新注册", func() bool { return rr.registrations() >= 2 })
+			rr.mutex.Lock()
+			server := rr.server
+			rr.mutex.Unlock()
+			eventually(t, "出现在注册列表中", func() bool {
+				_, entries := server.reg.listServices("Svc")
+				return len(entries) == 1
+			})
+			eventually(t, "记录新的纪元ID", func() bool {
+				c.keep.mutex.Lock()
+				defer c.keep.mutex.Unlock()
+				return c.keep.epoch == server.epoch
+			})
+		})
+	}
+}
+
+// TestSnapshotReload 注册中心重启后, 重新注册时收到的快照替换旧的provider
+func TestSnapshotReload(t *testing.T) {
+	rr, reg := newRestartableRegistry(t)
+	old := NewClient(ClientOptions{RegistryAddr: reg.URL})
+	oldEntry := newTestService(t, old, "Provider")
+	if err := old.Register(oldEntry); err != nil {
+		t.Fatal(err)
+	}
+	c := fastClient(reg.URL, time.Hour)
+	if err := c.Register(newTestService(t, c, "Consumer", "Provider")); err != nil {
+		t.Fatal(err)
+	}
+	if url, _ := c.GetProvider("Provider"); url != oldEntry.ServiceURL {
+		t.Fatalf("注册时应该收到Provider, 得到%q", url)
+	}
+
+	// 重启后只有新的Provider实例, 旧的实例没有机会发出下线通知
+	old.Close()
+	server := rr.restart()
+	fresh := NewClient(ClientOptions{RegistryAddr: reg.URL})
+	freshEntry := newTestService(t, fresh, "Provider")
+	freshEntry.InstanceID = "Provider-2"
+	if err := server.reg.addService(freshEntry); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "重新加载provider", func() bool {
+		url, err := c.GetProvider("Provider")
+		return err == nil && url == freshEntry.ServiceURL
+	})
+	c.prov.mutex.RLock()
+	defer c.prov.mutex.RUnlock()
+	if n := len(c.prov.services["Provider"]); n != 1 {
+		t.Fatalf("快照应该替换旧的实例, 还有%d个实例", n)
+	}
+}
+
+func TestWaitForProviderAfterRestart(t *testing.T) {
+	rr, reg := newRestartableRegistry(t)
+	c := fastClient(reg.URL, time.Hour)
+	if err := c.Register(newTestService(t, c, "Consumer", "Provider")); err != nil {
+		t.Fatal(err)
+	}
+	rr.restart()
+	p := NewClient(ClientOptions{RegistryAddr: reg.URL})
+	pe := newTestService(t, p, "Provider")
+	eventually(t, "重新注册", func() bool { return rr.registrations() >= 2 })
+	if err := p.Register(pe); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if url, err := c.WaitForProvider(ctx, "Provider"); err != nil || url != pe.ServiceURL {
+		t.Fatalf("重新注册后应该收到新的Provider, 得到%q, %v", url, err)
+	}
+}
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// MetricsPath 客户端的运行状态接口的默认路径, 和健康检查接口一起在注册服务时添加, 可以通过ClientOptions.MetricsPath修改
+const MetricsPath = "/metrics"
+
+// Metrics 客户端的运行状态
+type Metrics struct {
+	Breakers  []BreakerStats
+	Ejections []EjectionStats
+}
+
+func (c *Client) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(Metrics{
+		Breakers:  c.brk.stats(),
+		Ejections: c.outliers.stats(),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
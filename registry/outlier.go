@@ -0,0 +1,179 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 被动异常检测. 注册中心的健康检查只能发现完全不可用的实例, 而真实请求的结果更能反映实例的状态.
+// 客户端观察每次调用的结果, 某个实例连续出现5xx、超时或者连接被重置时, 把它在本地临时摘除(eject):
+// 1. 摘除时间按 基础时间*2^(摘除次数-1) 增长, 不超过上限, 反复出问题的实例被摘除得越来越久;
+// 2. 同一个服务被摘除的实例数量不超过一定比例, 避免误判时所有实例都不可用;
+// 3. 摘除时向注册中心上报一个warning级别的健康信号, 注册中心据此记录实例的状态.
+
+const (
+	outlierConsecutiveErrors = 3                // 连续多少次异常后摘除
+	outlierBaseEjection      = 10 * time.Second // 第一次摘除的时长
+	outlierMaxEjection       = 5 * time.Minute  // 摘除时长的上限
+	outlierMaxEjectPercent   = 0.5              // 一个服务最多摘除多少比例的实例
+	outlierRecoverySuccesses = 10               // 恢复后连续成功多少次, 摘除次数清零
+)
+
+type outlierState struct {
+	consecutive  int // 连续异常次数
+	successes    int // 恢复后连续成功次数
+	ejections    int // 摘除次数, 决定下一次摘除的时长
+	ejectedUntil time.Time
+}
+
+type outlierDetector struct {
+	services map[ServiceName]map[string]*outlierState
+	reporter ServiceName              // 上报健康信号时的上报者, 即本服务的名称
+	report   func(HealthSignal) error // 向注册中心上报健康信号
+	mutex    *sync.Mutex
+}
+
+// isOutlierError 只有5xx、超时和连接被重置算作异常, 其他错误(如请求被调用方取消)不算.
+func isOutlierError(err error) bool {
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &statusErr):
+		return true
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	}
+	return false
+}
+
+// filter 返回没有被摘除的实例
+func (o *outlierDetector) filter(name ServiceName, instances []Instance) []Instance {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	states := o.services[name]
+	if len(states) == 0 {
+		return instances
+	}
+	now := time.Now()
+	available := make([]Instance, 0, len(instances))
+	for _, ins := range instances {
+		if s, ok := states[ins.URL]; !ok || !now.Before(s.ejectedUntil) {
+			available = append(available, ins)
+		}
+	}
+	return available
+}
+
+// record 记录一次调用的结果, total是该服务当前的实例总数, 用于限制摘除比例.
+func (o *outlierDetector) record(name ServiceName, url string, err error, total int) {
+	o.mutex.Lock()
+	states, ok := o.services[name]
+	if !ok {
+		states = make(map[string]*outlierState)
+		o.services[name] = states
+	}
+	s, ok := states[url]
+	if !ok {
+		s = &outlierState{}
+		states[url] = s
+	}
+	now := time.Now()
+	if !isOutlierError(err) {
+		s.consecutive = 0
+		if s.ejections > 0 && !now.Before(s.ejectedUntil) {
+			if s.successes++; s.successes >= outlierRecoverySuccesses {
+				s.ejections, s.successes = 0, 0
+			}
+		}
+		o.mutex.Unlock()
+		return
+	}
+	s.successes = 0
+	s.consecutive++
+	if s.consecutive < outlierConsecutiveErrors || now.Before(s.ejectedUntil) {
+		o.mutex.Unlock()
+		return
+	}
+	ejected := 0
+	for _, other := range states {
+		if now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if ejected+1 > int(float64(total)*outlierMaxEjectPercent) {
+		// 超过摘除比例, 保留该实例
+		o.mutex.Unlock()
+		return
+	}
+	s.ejections++
+	s.consecutive = 0
+	d := min(outlierBaseEjection<<(s.ejections-1), outlierMaxEjection)
+	s.ejectedUntil = now.Add(d)
+	reporter := o.reporter
+	o.mutex.Unlock()
+
+	// record在请求的done中同步调用, 而标准库log的输出可能正是通过这个Transport发往日志服务的请求,
+	// Logger在Write期间持有自己的锁, 同步输出会死锁. 日志和上报都放在单独的goroutine中.
+	go func() {
+		log.Printf("实例%s(%s)连续出现异常, 本地摘除%v: %v\n", url, name, d, err)
+		sig := HealthSignal{
+			ServiceName: name,
+			ServiceURL:  url,
+			Level:       HealthWarning,
+			Reporter:    reporter,
+			Reason:      "outlier ejected: " + err.Error(),
+			Expires:     now.Add(d),
+		}
+		if err := o.report(sig); err != nil {
+			log.Printf("上报健康信号失败: %v\n", err)
+		}
+	}()
+}
+
+func (o *outlierDetector) setReporter(name ServiceName) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.reporter = name
+}
+
+// forget 实例从provider中移除后, 不再需要它的状态
+func (o *outlierDetector) forget(name ServiceName, url string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.services[name], url)
+}
+
+// EjectionStats 被摘除的实例, 通过/metrics接口对外展示
+type EjectionStats struct {
+	Service   ServiceName
+	URL       string
+	Ejections int
+	Until     time.Time
+}
+
+func (o *outlierDetector) stats() []EjectionStats {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	now := time.Now()
+	stats := make([]EjectionStats, 0)
+	for name, states := range o.services {
+		for url, s := range states {
+			if now.Before(s.ejectedUntil) {
+				stats = append(stats, EjectionStats{Service: name, URL: url, Ejections: s.ejections, Until: s.ejectedUntil})
+			}
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
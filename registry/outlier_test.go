@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsOutlierError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"成功", nil, false},
+		{"调用方取消", context.Canceled, false},
+		{"5xx", &StatusError{URL: "http://a", Code: 503}, true},
+		{"超时", context.DeadlineExceeded, true},
+		{"网络超时", &net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{"连接被重置", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"连接意外关闭", io.ErrUnexpectedEOF, true},
+		{"其他错误", errors.New("bad request"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOutlierError(tt.err); got != tt.want {
+				t.Fatalf("isOutlierError(%v) = %v, 期望%v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func newOutlierDetector() (*outlierDetector, chan HealthSignal) {
+	signals := make(chan HealthSignal, 10)
+	return &outlierDetector{
+		services: make(map[ServiceName]map[string]*outlierState),
+		reporter: "Caller",
+		report: func(sig HealthSignal) error {
+			signals <- sig
+			return nil
+		},
+		mutex: &sync.Mutex{},
+	}, signals
+}
+
+func TestOutlierEjection(t *testing.T) {
+	fail := &StatusError{URL: "http://a", Code: 500}
+	instances := []Instance{{URL: "http://a"}, {URL: "http://b"}, {URL: "http://c"}, {URL: "http://d"}}
+	tests := []struct {
+		name    string
+		results map[string][]error // 每个实例依次上报的结果
+		ejected []string
+	}{
+		{"连续两次异常不摘除", map[string][]error{"http://a": {fail, fail}}, nil},
+		{"连续三次异常摘除", map[string][]error{"http://a": {fail, fail, fail}}, []string{"http://a"}},
+		{"成功打断连续异常", map[string][]error{"http://a": {fail, fail, nil, fail}}, nil},
+		{
+			"摘除数量不超过一半",
+			map[string][]error{
+				"http://a": {fail, fail, fail},
+				"http://b": {fail, fail, fail},
+				"http://c": {fail, fail, fail},
+			},
+			[]string{"http://a", "http://b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, signals := newOutlierDetector()
+			for _, ins := range instances {
+				for _, err := range tt.results[ins.URL] {
+					o.record("Svc", ins.URL, err, len(instances))
+				}
+			}
+			var ejected []string
+			for _, s := range o.stats() {
+				ejected = append(ejected, s.URL)
+			}
+			if fmt.Sprint(ejected) != fmt.Sprint(tt.ejected) {
+				t.Fatalf("摘除了%v, 期望%v", ejected, tt.ejected)
+			}
+			if available := o.filter("Svc", instances); len(available) != len(instances)-len(tt.ejected) {
+				t.Fatalf("可用实例%v", available)
+			}
+			for range tt.ejected {
+				select {
+				case sig := <-signals:
+					if sig.Level != HealthWarning || sig.Reporter != "Caller" {
+						t.Fatalf("健康信号不正确: %+v", sig)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("摘除时没有上报健康信号")
+				}
+			}
+		})
+	}
+}
+
+// TestOutlierBackoff 反复被摘除的实例, 摘除时间成倍增长
+func TestOutlierBackoff(t *testing.T) {
+	o, _ := newOutlierDetector()
+	fail := &StatusError{URL: "http://a", Code: 500}
+	var durations []time.Duration
+	for range 3 {
+		for range outlierConsecutiveErrors {
+			o.record("Svc", "http://a", fail, 4)
+		}
+		o.mutex.Lock()
+		s := o.services["Svc"]["http://a"]
+		durations = append(durations, time.Until(s.ejectedUntil).Round(time.Second))
+		s.ejectedUntil = time.Now() // 摘除结束
+		o.mutex.Unlock()
+	}
+	want := []time.Duration{outlierBaseEjection, 2 * outlierBaseEjection, 4 * outlierBaseEjection}
+	if fmt.Sprint(durations) != fmt.Sprint(want) {
+		t.Fatalf("摘除时长%v, 期望%v", durations, want)
+	}
+}
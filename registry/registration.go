@@ -1,11 +1,26 @@
 package registry
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
 type RegistrationEntry struct {
 	ServiceName      ServiceName // 自定义类型, 可以扩展功能
+	InstanceID       string      // 实例ID, 同一个服务的多个实例用它区分, 例如"GradingService-2"
 	ServiceURL       string
 	RequiredServices []ServiceName // 依赖的服务, 在注册时请求这些服务
 	ServiceUpdateURL string
 	HeartbeatURL     string
+	Weight           int // 实例权重, 供依赖本服务的服务做加权负载均衡, 小于等于0时按1处理
+	// Version 服务的构建版本, 注册中心据此报告同一个服务的实例之间的版本偏差
+	Version string `json:",omitempty"`
+	// ProtocolVersion 实例使用的注册协议版本, Client.Register自动填写. 为0表示还没有版本号的旧客户端, 按1处理.
+	ProtocolVersion int `json:",omitempty"`
+	// LoadBalancing 为依赖的服务选择负载均衡策略, 没有指定的使用随机策略. 只在客户端使用, 不发送给注册中心.
+	LoadBalancing map[ServiceName]BalancerType `json:"-"`
 }
 
 type ServiceName string
@@ -13,15 +28,104 @@ type ServiceName string
 const (
 	LogService     = ServiceName("LogService")
 	GradingService = ServiceName("GradingService")
+	GatewayService = ServiceName("Gateway")
+	TracingService = ServiceName("TracingService")
+	// RegistryServiceName 注册中心自己记录span和健康信号时使用的服务名, 注册中心不注册自己
+	RegistryServiceName = ServiceName("Registry")
+)
+
+// 注册协议的版本. 注册信息、patch或健康检查报告的格式发生不兼容的变化时增加ProtocolVersion,
+// 注册中心拒绝不在[MinProtocolVersion, ProtocolVersion]范围内的注册.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
 )
 
+// ErrIncompatibleProtocol 注册使用的协议版本不被注册中心支持
+var ErrIncompatibleProtocol = errors.New("不兼容的注册协议版本")
+
+// checkProtocol 检查注册信息的协议版本
+func checkProtocol(re RegistrationEntry) error {
+	v := re.ProtocolVersion
+	if v == 0 {
+		v = 1
+	}
+	if v < MinProtocolVersion || v > ProtocolVersion {
+		return fmt.Errorf("%w: %s使用%d, 注册中心支持%d到%d", ErrIncompatibleProtocol, re.ServiceName, v, MinProtocolVersion, ProtocolVersion)
+	}
+	return nil
+}
+
+// ServiceVersions 一个服务的实例按版本分组, 实例的版本不一致时Skew为true
+type ServiceVersions struct {
+	ServiceName ServiceName
+	Versions    map[string][]string // 版本 -> 实例ID, 没有上报版本的实例记为"unknown"
+	Skew        bool
+}
+
 // patchEntry 表示每次服务变更时, 注册中心发送的更新内容
 type patchEntry struct {
-	Name ServiceName
-	URL  string
+	Name   ServiceName
+	URL    string
+	Weight int
 }
 
 type patch struct {
 	Added   []patchEntry
 	Removed []patchEntry
+	// Snapshot 为true时表示这是依赖服务的完整快照, 接收方用它整体替换本地的provider,
+	// 用于(重新)注册时重新加载依赖信息.
+	Snapshot bool
+	// Epoch 发出patch的注册中心的纪元ID, Seq 生成patch时注册列表的版本号, 同一个纪元内单调递增.
+	// 每个patch由单独的goroutine发送, 到达的顺序可能和生成的顺序不同, 接收方据此丢弃过期的patch.
+	// 旧的注册中心不发送这两个字段, 为零值时按到达的顺序处理.
+	Epoch string `json:",omitempty"`
+	Seq   uint64 `json:",omitempty"`
+}
+
+// EpochHeader 注册中心在每次响应注册和续约请求时携带的响应头, 值为注册中心本次启动的纪元ID.
+// 注册中心重启后纪元ID会变化, 客户端据此判断注册中心是否丢失了注册信息.
+const EpochHeader = "X-Registry-Epoch"
+
+type HealthLevel string
+
+const (
+	HealthPassing  HealthLevel = "passing"
+	HealthWarning  HealthLevel = "warning"
+	HealthCritical HealthLevel = "critical"
+)
+
+// HealthReport 服务健康检查接口返回的JSON. Status为critical时接口返回503, 否则返回200.
+// 注册中心的健康检查会解析它: critical的实例按检查失败处理, warning的实例保留在注册列表中, 但记录一个健康信号.
+type HealthReport struct {
+	Status HealthLevel
+	Checks []CheckResult
+}
+
+// CheckResult 一项检查的结果
+type CheckResult struct {
+	Name   string
+	Status HealthLevel
+	Error  string `json:",omitempty"`
+}
+
+// Failed 返回没有通过的检查, 用于日志和健康信号的原因
+func (r HealthReport) Failed() string {
+	var failed []string
+	for _, c := range r.Checks {
+		if c.Status != HealthPassing {
+			failed = append(failed, c.Name+": "+c.Error)
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// HealthSignal 服务对依赖实例健康状态的反馈, 例如本地摘除了异常实例时上报一个warning级别的信号.
+type HealthSignal struct {
+	ServiceName ServiceName // 被上报的实例
+	ServiceURL  string
+	Level       HealthLevel
+	Reporter    ServiceName // 上报者
+	Reason      string
+	Expires     time.Time // 信号的有效期, 过期后注册中心不再展示
 }
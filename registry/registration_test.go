@@ -0,0 +1,28 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		wantErr bool
+	}{
+		{"旧客户端没有版本号", 0, false},
+		{"当前版本", ProtocolVersion, false},
+		{"最低版本", MinProtocolVersion, false},
+		{"版本过高", ProtocolVersion + 1, true},
+		{"非法版本", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProtocol(RegistrationEntry{ServiceName: "Svc", ProtocolVersion: tt.version})
+			if tt.wantErr != errors.Is(err, ErrIncompatibleProtocol) {
+				t.Fatalf("checkProtocol(%d) = %v", tt.version, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package registry
+
+import "context"
+
+// 请求ID用于把一次调用在各个服务中的日志串起来. 服务收到请求时把请求ID放进ctx,
+// 之后通过Transport发出的请求会在RequestIDHeader中带上它, 下游服务继续沿用同一个ID.
+
+// RequestIDHeader 传递请求ID的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID 返回带有请求ID的ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom 返回ctx中的请求ID, 没有时返回空字符串
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
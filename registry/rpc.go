@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"time"
+)
+
+// 注册中心除了REST接口, 还通过 net/rpc/jsonrpc 提供一套带类型的RPC接口, 供内部的Go工具使用.
+// 两种接口背后是同一个registry实例和同一套方法, 行为保持一致:
+//   Register   -> POST /services
+//   Deregister -> DELETE /services
+//   Renew      -> PUT /services
+//   List       -> GET /services?name=
+//   Watch      -> GET /services?name=&watch=<version>&timeout=
+//   Report     -> POST /signals
+
+// RPCPort 注册中心JSON-RPC接口监听的端口
+const RPCPort = ":10009"
+const RPCAddr = "localhost" + RPCPort
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+)
+
+// RegisterReply Register和Renew的返回值, 携带注册中心的纪元ID
+type RegisterReply struct {
+	Epoch string
+}
+
+type ListArgs struct {
+	ServiceName ServiceName // 为空时返回全部服务
+}
+
+type ListReply struct {
+	Version  uint64 // 注册列表的版本号, 作为下一次Watch的参数
+	Services []RegistrationEntry
+}
+
+type WatchArgs struct {
+	ServiceName ServiceName
+	Version     uint64        // 上一次List/Watch返回的版本号, 注册列表版本不等于它时立即返回
+	Timeout     time.Duration // 最长等待时间, 为0时使用默认值
+}
+
+// RegistryRPC RPC接口的接收者, 以"Registry"为名注册到rpc.Server, 方法名如"Registry.Register".
+type RegistryRPC struct {
+	server *Server
+}
+
+func (rr RegistryRPC) Register(entry RegistrationEntry, reply *RegisterReply) error {
+	log.Printf("RPC adding service: %+v\n", entry)
+	if err := rr.server.reg.addService(entry); err != nil {
+		return err
+	}
+	reply.Epoch = rr.server.epoch
+	return nil
+}
+
+func (rr RegistryRPC) Deregister(entry RegistrationEntry, reply *RegisterReply) error {
+	log.Printf("RPC removing service: %+v\n", entry)
+	if err := rr.server.reg.removeService(entry); err != nil {
+		return err
+	}
+	reply.Epoch = rr.server.epoch
+	return nil
+}
+
+func (rr RegistryRPC) Renew(entry RegistrationEntry, reply *RegisterReply) error {
+	if err := rr.server.reg.renewService(entry); err != nil {
+		return err
+	}
+	reply.Epoch = rr.server.epoch
+	return nil
+}
+
+func (rr RegistryRPC) List(args ListArgs, reply *ListReply) error {
+	reply.Version, reply.Services = rr.server.reg.listServices(args.ServiceName)
+	return nil
+}
+
+func (rr RegistryRPC) Watch(args WatchArgs, reply *ListReply) error {
+	timeout := args.Timeout
+	if timeout <= 0 {
+		timeout = defaultWatchTimeout
+	}
+	reply.Version, reply.Services = rr.server.reg.watchServices(args.ServiceName, args.Version, min(timeout, maxWatchTimeout))
+	return nil
+}
+
+func (rr RegistryRPC) Report(sig HealthSignal, reply *RegisterReply) error {
+	rr.server.signals.report(sig)
+	reply.Epoch = rr.server.epoch
+	return nil
+}
+
+// ServeRPC 在addr上为默认的注册中心提供JSON-RPC服务
+func ServeRPC(addr string) error {
+	return defaultServer.ServeRPC(addr)
+}
+
+// ServeRPC 在addr上监听并为每个连接提供JSON-RPC服务, 监听失败或者注册中心关闭时返回.
+func (s *Server) ServeRPC(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serveRPC(listener)
+}
+
+// serveRPC 在listener上接受连接, 直到listener关闭. 注册中心关闭时一并关闭listener和已经接受的连接.
+func (s *Server) serveRPC(listener net.Listener) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Registry", RegistryRPC{server: s}); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		_ = listener.Close()
+		return net.ErrClosed
+	}
+	s.listeners = append(s.listeners, listener)
+	s.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			_ = conn.Close()
+			return net.ErrClosed
+		}
+		s.conns[conn] = true
+		s.mutex.Unlock()
+		go func() {
+			server.ServeCodec(jsonrpc.NewServerCodec(conn))
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+// RPCClient 注册中心的RPC客户端. 底层复用同一个TCP连接, 可以被多个goroutine并发使用.
+type RPCClient struct {
+	client *rpc.Client
+}
+
+func DialRPC(addr string) (*RPCClient, error) {
+	client, err := jsonrpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &RPCClient{client: client}, nil
+}
+
+func (c *RPCClient) Register(entry RegistrationEntry) (string, error) {
+	var reply RegisterReply
+	err := c.client.Call("Registry.Register", entry, &reply)
+	return reply.Epoch, err
+}
+
+func (c *RPCClient) Deregister(entry RegistrationEntry) error {
+	var reply RegisterReply
+	return c.client.Call("Registry.Deregister", entry, &reply)
+}
+
+func (c *RPCClient) Renew(entry RegistrationEntry) (string, error) {
+	var reply RegisterReply
+	err := c.client.Call("Registry.Renew", entry, &reply)
+	return reply.Epoch, err
+}
+
+func (c *RPCClient) List(name ServiceName) (ListReply, error) {
+	var reply ListReply
+	err := c.client.Call("Registry.List", ListArgs{ServiceName: name}, &reply)
+	return reply, err
+}
+
+// Watch 阻塞直到注册列表的版本号不等于version或者超时.
+func (c *RPCClient) Watch(name ServiceName, version uint64, timeout time.Duration) (ListReply, error) {
+	var reply ListReply
+	err := c.client.Call("Registry.Watch", WatchArgs{ServiceName: name, Version: version, Timeout: timeout}, &reply)
+	return reply, err
+}
+
+func (c *RPCClient) Report(sig HealthSignal) error {
+	var reply RegisterReply
+	return c.client.Call("Registry.Report", sig, &reply)
+}
+
+func (c *RPCClient) Close() error {
+	return c.client.Close()
+}
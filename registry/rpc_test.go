@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// registryAPI 注册中心接口, 分别用REST和RPC实现, 同样的调用应该得到同样的结果
+type registryAPI interface {
+	Register(RegistrationEntry) (string, error)
+	Deregister(RegistrationEntry) error
+	Renew(RegistrationEntry) (string, error)
+	List(ServiceName) (ListReply, error)
+	Watch(ServiceName, uint64, time.Duration) (ListReply, error)
+	Report(HealthSignal) error
+}
+
+// restAPI 通过REST接口调用注册中心
+type restAPI struct {
+	url string
+}
+
+func (a restAPI) do(method, query string, body any) (*http.Response, error) {
+	buffer := bytes.NewBuffer(nil)
+	if body != nil {
+		if err := json.NewEncoder(buffer).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, a.url+query, buffer)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("状态码: %d", res.StatusCode)
+	}
+	return res, nil
+}
+
+func (a restAPI) epoch(method string, entry RegistrationEntry) (string, error) {
+	res, err := a.do(method, "/services", entry)
+	if err != nil {
+		return "", err
+	}
+	_ = res.Body.Close()
+	return res.Header.Get(EpochHeader), nil
+}
+
+func (a restAPI) list(query string) (ListReply, error) {
+	var reply ListReply
+	res, err := a.do(http.MethodGet, query, nil)
+	if err != nil {
+		return reply, err
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(&reply)
+	return reply, err
+}
+
+func (a restAPI) Register(entry RegistrationEntry) (string, error) {
+	return a.epoch(http.MethodPost, entry)
+}
+
+func (a restAPI) Deregister(entry RegistrationEntry) error {
+	_, err := a.epoch(http.MethodDelete, entry)
+	return err
+}
+
+func (a restAPI) Renew(entry RegistrationEntry) (string, error) {
+	return a.epoch(http.MethodPut, entry)
+}
+
+func (a restAPI) List(name ServiceName) (ListReply, error) {
+	return a.list("/services?name=" + url.QueryEscape(string(name)))
+}
+
+func (a restAPI) Watch(name ServiceName, version uint64, timeout time.Duration) (ListReply, error) {
+	return a.list(fmt.Sprintf("/services?name=%s&watch=%d&timeout=%s", url.QueryEscape(string(name)), version, timeout))
+}
+
+func (a restAPI) Report(sig HealthSignal) error {
+	res, err := a.do(http.MethodPost, "/signals", sig)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// newRPCRegistry 在回环地址上运行一个只提供RPC接口的注册中心, 返回它和连接到它的客户端
+func newRPCRegistry(t *testing.T) (*Server, *RPCClient) {
+	t.Helper()
+	s := NewServer(ServerOptions{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.serveRPC(listener) }()
+	c, err := DialRPC(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = s.Close()
+	})
+	return s, c
+}
+
+// TestRPCMatchesREST 对REST和RPC接口执行同样的操作, 比较每一步的结果
+func TestRPCMatchesREST(t *testing.T) {
+	// 两个注册中心推送patch的服务, 对所有请求返回200
+	svc := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer svc.Close()
+	entry := func(name ServiceName, requires ...ServiceName) RegistrationEntry {
+		return RegistrationEntry{
+			ServiceName:      name,
+			InstanceID:       string(name) + "-1",
+			ServiceURL:       svc.URL + "/" + string(name),
+			RequiredServices: requires,
+			ServiceUpdateURL: svc.URL + "/services",
+			HeartbeatURL:     svc.URL + "/health",
+			Version:          "v1",
+			ProtocolVersion:  ProtocolVersion,
+		}
+	}
+	a, b := entry("A"), entry("B", "A")
+	incompatible := entry("C")
+	incompatible.ProtocolVersion = ProtocolVersion + 1
+
+	// run 执行一系列操作, 把每一步的结果记录为字符串. epoch 注册中心的纪元ID, 注册和续约返回的纪元ID应该和它相同
+	run := func(api registryAPI, epoch string, signals func() int) []string {
+		var steps []string
+		record := func(step string, result any, err error) {
+			if err != nil {
+				result = "error"
+			}
+			data, _ := json.Marshal(result)
+			steps = append(steps, step+": "+string(data))
+		}
+		checkEpoch := func(e string, err error) bool { return err == nil && e == epoch }
+
+		record("Register A", checkEpoch(api.Register(a)), nil)
+		record("Register B", checkEpoch(api.Register(b)), nil)
+		_, err := api.Register(incompatible)
+		record("Register incompatible", "ok", err)
+		all, err := api.List("")
+		record("List", all, err)
+		reply, err := api.List("A")
+		record("List A", reply, err)
+		record("Renew A", checkEpoch(api.Renew(a)), nil)
+		_, err = api.Renew(entry("Unknown"))
+		record("Renew unknown", "ok", err)
+		reply, err = api.Watch("A", all.Version-1, time.Second)
+		record("Watch old version", reply, err)
+		reply, err = api.Watch("A", all.Version, 50*time.Millisecond)
+		record("Watch timeout", reply, err)
+		err = api.Report(HealthSignal{ServiceName: "A", ServiceURL: a.ServiceURL, Level: HealthWarning, Reporter: "B", Expires: time.Now().Add(time.Minute)})
+		record("Report", signals(), err)
+		record("Deregister A", "ok", api.Deregister(a))
+		reply, err = api.List("")
+		record("List after deregister", reply, err)
+		return steps
+	}
+
+	restServer, restSrv := newTestRegistry(t)
+	rest := run(restAPI{url: restSrv.URL}, restServer.epoch, func() int { return len(restServer.signals.active()) })
+	rpcServer, rpcClient := newRPCRegistry(t)
+	rpc := run(rpcClient, rpcServer.epoch, func() int { return len(rpcServer.signals.active()) })
+
+	if !reflect.DeepEqual(rest, rpc) {
+		t.Fatalf("REST和RPC的结果不同:\nREST: %q\nRPC:  %q", rest, rpc)
+	}
+	// 确认比较的是有意义的结果, 而不是两边都失败
+	want := map[int]string{
+		0: `Register A: true`,
+		2: `Register incompatible: "error"`,
+		6: `Renew unknown: "error"`,
+		9: `Report: 1`,
+	}
+	for i, w := range want {
+		if rest[i] != w {
+			t.Errorf("第%d步为%s, 期望%s", i, rest[i], w)
+		}
+	}
+	if _, all := restServer.reg.listServices(""); len(all) != 1 || all[0].ServiceName != "B" {
+		t.Errorf("注销A后应该只剩B, 得到%+v", all)
+	}
+}
+
+// TestCloseRPCConns 关闭注册中心时关闭已经接受的RPC连接, 阻塞在Watch上的调用立即返回
+func TestCloseRPCConns(t *testing.T) {
+	s, c := newRPCRegistry(t)
+	reply, err := c.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Watch("", reply.Version, time.Minute)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("注册中心关闭后Watch应该返回错误")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("注册中心关闭后Watch仍然阻塞")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.conns) != 0 {
+		t.Fatalf("关闭后还有%d个RPC连接", len(s.conns))
+	}
+}
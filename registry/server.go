@@ -1,12 +1,19 @@
 package registry
 
 import (
+	"DistributedGo/tracing"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -14,83 +21,149 @@ import (
 const ServerPort = ":10000"
 const ServicesURL = "http://localhost" + ServerPort + "/services"
 
-// 包中的变量都是包级的, 私有的, 不需要对外暴露. 因为这些服务是通过http请求handler来调用的.
-// 将handler实现在当前包中.
+// ServerOptions 注册中心的配置, 零值即可使用
+type ServerOptions struct {
+	HTTPClient         *http.Client // 健康检查和推送依赖变更时使用的客户端, 为nil时新建一个
+	HealthCheckWorkers int          // 健康检查的worker数量, 为0时使用默认值
+}
 
-// 创建包级的类型, 保存注册服务的信息
-type registry struct {
-	services []RegistrationEntry
-	// 上面的slice字段是线程不安全的, 需要加锁保护
-	mutex *sync.RWMutex
+// Server 注册中心. 注册列表、健康检查调度器、健康信号、ServeMux和后台goroutine都由它自己持有,
+// 同一个进程中可以创建多个互不影响的注册中心. 包级的函数和handler类型使用默认的注册中心, 保持原来的用法.
+type Server struct {
+	reg     *registry
+	health  *healthScheduler
+	signals *signalStore
+	// epoch 注册中心本次启动的纪元ID, 每次启动都不同. 客户端通过它发现注册中心重启.
+	epoch string
+	mux   *http.ServeMux
+	once  sync.Once
+	// 关闭注册中心时需要关闭的RPC监听和已经接受的RPC连接
+	listeners []net.Listener
+	conns     map[net.Conn]bool
+	closed    bool
+	mutex     *sync.Mutex
 }
 
-// healthCheck 一段无限循环的函数, 定期请求服务的健康检查端点, 以此判断服务是否存活
-// 逻辑是：尝试3次健康检查, 一次成功就通过, 失败了就让该服务下线, 如果后续又恢复了, 则重新注册.
-func (r *registry) healthCheck(freq time.Duration) bool {
-	for {
-		wg := sync.WaitGroup{}
-		for _, service := range r.services {
-			wg.Add(1)
-			go func(re RegistrationEntry) {
-				defer wg.Done()
-				success := true
-				for attempts := 0; attempts < 3; attempts++ {
-					resp, err := http.Get(re.HeartbeatURL)
-					// 下面这个逻辑是让我学到了if/else if/else的流程控制. 如果失败就走下面处理失败的逻辑, 成功就跳出循环.
-					if err != nil || resp.StatusCode != http.StatusOK {
-						log.Printf("Heartbeat failed for service: %s, error: %s\n", re.ServiceName, err.Error())
-					} else if resp.StatusCode == http.StatusOK {
-						// 心跳成功
-						if !success {
-							// 服务恢复了, 重新注册
-							log.Printf("Service %s has recovered. Re-registering.\n", re.ServiceName)
-							err := r.addService(re)
-							if err != nil {
-								log.Printf("Failed to re-register service %s: %v\n", re.ServiceName, err)
-							}
-						}
-						break
-					}
-					log.Printf("Heartbeat attempt %d failed for service: %s\n", attempts+1, re.ServiceName)
-					if success {
-						// 第一次失败, 标记为失败
-						success = false
-						err := r.removeService(re)
-						if err != nil {
-							log.Printf("Failed to re-register service %s: %v\n", re.ServiceName, err)
-						}
-					}
-					time.Sleep(1 * time.Second)
-				}
-			}(service)
-		}
-		wg.Wait()
-		time.Sleep(freq)
+func NewServer(opts ServerOptions) *Server {
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{}
 	}
+	workers := opts.HealthCheckWorkers
+	if workers <= 0 {
+		workers = healthCheckWorkers
+	}
+	health := newHealthScheduler(client, workers)
+	epoch := strconv.FormatInt(time.Now().UnixNano(), 36)
+	signals := &signalStore{
+		signals: make(map[string]map[ServiceName]HealthSignal),
+		mutex:   &sync.Mutex{},
+	}
+	health.signal = signals.report
+	s := &Server{
+		reg: &registry{
+			services: make([]RegistrationEntry, 0),
+			mutex:    &sync.RWMutex{},
+			changed:  make(chan struct{}),
+			health:   health,
+			client:   client,
+			epoch:    epoch,
+		},
+		health:  health,
+		signals: signals,
+		epoch:   epoch,
+		mux:     http.NewServeMux(),
+		conns:   make(map[net.Conn]bool),
+		mutex:   &sync.Mutex{},
+	}
+	s.mux.HandleFunc("/services", s.serveServices)
+	s.mux.HandleFunc("/signals", s.serveSignals)
+	s.mux.HandleFunc("/versions", s.serveVersions)
+	return s
 }
 
-// 只执行一次的启动健康检查的函数
-var once sync.Once
+// Handler 注册中心的HTTP接口: /services、/signals 和 /versions
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
 
-func StartHealthCheck() {
-	once.Do(func() {
-		go reg.healthCheck(3 * time.Second)
+// StartHealthCheck 启动健康检查的worker, 多次调用只启动一次.
+func (s *Server) StartHealthCheck() {
+	s.once.Do(func() {
+		s.health.start(s.reg)
 	})
 }
 
-// 注册服务的方法
+// Lookup 返回服务的一个实例URL, 注册中心自己需要调用其他服务(如TracingService)时使用
+func (s *Server) Lookup(name ServiceName) (string, error) {
+	if _, entries := s.reg.listServices(name); len(entries) > 0 {
+		return entries[0].ServiceURL, nil
+	}
+	return "", fmt.Errorf("服务不存在: %s", name)
+}
+
+// Close 停止健康检查, 关闭RPC监听和RPC连接, 阻塞在Watch上的RPC调用随之返回. 关闭HTTP服务由调用方负责.
+func (s *Server) Close() error {
+	s.health.stop()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	var errs []error
+	for _, l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	s.listeners = nil
+	for conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, conn)
+	}
+	return errors.Join(errs...)
+}
+
+// 保存注册服务的信息
+type registry struct {
+	services []RegistrationEntry
+	// 上面的slice字段是线程不安全的, 需要加锁保护
+	mutex *sync.RWMutex
+	// version 注册列表每变化一次加1, changed 在变化时关闭并替换, 用于Watch等待变化
+	version uint64
+	changed chan struct{}
+	health  *healthScheduler
+	client  *http.Client
+	epoch   string // 注册中心的纪元ID, 和version一起写入patch
+}
+
+// 注册服务的方法. 重复注册同一个服务实例时不会重复添加, 但仍然会重新发送依赖服务的快照.
 func (r *registry) addService(re RegistrationEntry) error {
+	if err := checkProtocol(re); err != nil {
+		return err
+	}
 	r.mutex.Lock()
-	r.services = append(r.services, re)
+	if i := r.indexOf(re); i < 0 {
+		r.services = append(r.services, re)
+		r.bump()
+	} else if !reflect.DeepEqual(r.services[i], re) {
+		// 同一个实例升级、调整权重或者修改依赖的服务后重新注册, 整个替换旧的注册信息
+		r.services[i] = re
+		r.bump()
+	}
+	skew := r.versionsOf(re.ServiceName)
+	seq := r.version
 	r.mutex.Unlock()
+	if skew.Skew {
+		log.Printf("Version skew in %s: %v\n", skew.ServiceName, skew.Versions)
+	}
+	r.health.track(re)
 	err := r.sendRequiredServices(re)
 	r.notify(&patch{
 		Added: []patchEntry{
 			{
-				Name: re.ServiceName,
-				URL:  re.ServiceURL,
+				Name:   re.ServiceName,
+				URL:    re.ServiceURL,
+				Weight: re.Weight,
 			},
 		},
+		Seq: seq,
 	})
 	if err != nil {
 		return err
@@ -98,75 +171,192 @@ func (r *registry) addService(re RegistrationEntry) error {
 	return nil
 }
 
-// 取消注册服务的方法
-func (r *registry) removeService(entry RegistrationEntry) error {
+// indexOf 查找服务实例在注册列表中的位置, 不存在时返回-1. 调用方需要持有锁.
+func (r *registry) indexOf(entry RegistrationEntry) int {
 	for i, e := range r.services {
 		if e.ServiceName == entry.ServiceName && e.ServiceURL == entry.ServiceURL {
-			// 找到匹配的服务, 删除它
-			r.mutex.Lock()
-			r.services = append(r.services[:i], r.services[i+1:]...)
-			r.mutex.Unlock()
-			r.notify(&patch{
-				Removed: []patchEntry{
-					{
-						Name: entry.ServiceName,
-						URL:  entry.ServiceURL,
-					},
-				},
-			})
-			return nil
+			return i
+		}
+	}
+	return -1
+}
+
+// versionsOf 按版本分组一个服务的实例. 调用方需要持有锁.
+func (r *registry) versionsOf(name ServiceName) ServiceVersions {
+	sv := ServiceVersions{ServiceName: name, Versions: make(map[string][]string)}
+	for _, e := range r.services {
+		if e.ServiceName != name {
+			continue
+		}
+		version, id := e.Version, e.InstanceID
+		if version == "" {
+			version = "unknown"
+		}
+		if id == "" {
+			id = e.ServiceURL
+		}
+		sv.Versions[version] = append(sv.Versions[version], id)
+	}
+	sv.Skew = len(sv.Versions) > 1
+	return sv
+}
+
+// versions 所有服务的版本分布, 按服务名排序
+func (r *registry) versions() []ServiceVersions {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var names []ServiceName
+	for _, e := range r.services {
+		if !slices.Contains(names, e.ServiceName) {
+			names = append(names, e.ServiceName)
 		}
 	}
-	return fmt.Errorf("service with name %s and URL %s not found", entry.ServiceName, entry.ServiceURL)
+	slices.Sort(names)
+	list := make([]ServiceVersions, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.versionsOf(name))
+	}
+	return list
 }
 
+// bump 注册列表发生变化, 唤醒所有等待变化的Watch. 调用方需要持有写锁.
+func (r *registry) bump() {
+	r.version++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// listServices 返回注册列表的版本号和副本, name为空时返回全部服务.
+func (r *registry) listServices(name ServiceName) (uint64, []RegistrationEntry) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	entries := make([]RegistrationEntry, 0, len(r.services))
+	for _, e := range r.services {
+		if name == "" || e.ServiceName == name {
+			entries = append(entries, e)
+		}
+	}
+	return r.version, entries
+}
+
+// watchServices 阻塞直到注册列表的版本号不等于version或者超时, 然后返回最新的列表.
+// 调用方拿到新的版本号后再次调用, 就可以持续监听注册列表的变化.
+func (r *registry) watchServices(name ServiceName, version uint64, timeout time.Duration) (uint64, []RegistrationEntry) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.mutex.RLock()
+		current, changed := r.version, r.changed
+		r.mutex.RUnlock()
+		if current != version {
+			return r.listServices(name)
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return r.listServices(name)
+		}
+	}
+}
+
+// renewService 服务续约, 只确认服务实例仍在注册列表中. 注册中心重启后列表为空, 客户端会收到错误并重新注册.
+func (r *registry) renewService(entry RegistrationEntry) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.indexOf(entry) < 0 {
+		return fmt.Errorf("service with name %s and URL %s not found", entry.ServiceName, entry.ServiceURL)
+	}
+	return nil
+}
+
+// 取消注册服务的方法. 主动注销的服务不再进行健康检查.
+func (r *registry) removeService(entry RegistrationEntry) error {
+	r.health.untrack(entry)
+	return r.dropService(entry)
+}
+
+// dropService 从注册列表中删除服务并通知依赖它的服务. 健康检查失败时也调用它, 但保留健康检查以便服务恢复.
+func (r *registry) dropService(entry RegistrationEntry) error {
+	r.mutex.Lock()
+	i := r.indexOf(entry)
+	if i < 0 {
+		r.mutex.Unlock()
+		return fmt.Errorf("service with name %s and URL %s not found", entry.ServiceName, entry.ServiceURL)
+	}
+	// 找到匹配的服务, 删除它
+	r.services = append(r.services[:i], r.services[i+1:]...)
+	r.bump()
+	seq := r.version
+	r.mutex.Unlock()
+	r.notify(&patch{
+		Removed: []patchEntry{
+			{
+				Name: entry.ServiceName,
+				URL:  entry.ServiceURL,
+			},
+		},
+		Seq: seq,
+	})
+	return nil
+}
+
+// notify 将变更通知给依赖变更服务的服务. 先在锁内拿到注册列表的快照, 只为真正需要通知的服务启动goroutine.
+// fullPatch.Seq是修改注册列表时的版本号, 发送的patch都带上它, 接收方丢弃比已经处理过的更旧的patch.
 func (r *registry) notify(fullPatch *patch) {
-	for _, entry := range r.services {
-		go func(re RegistrationEntry) {
-			for _, reqServiceName := range re.RequiredServices {
-				p := patch{
-					Added:   []patchEntry{},
-					Removed: []patchEntry{},
-				}
-				sendUpdate := false
-				for _, added := range fullPatch.Added {
-					if added.Name == reqServiceName {
-						p.Added = append(p.Added, added)
-						sendUpdate = true
-					}
+	r.mutex.RLock()
+	services := slices.Clone(r.services)
+	r.mutex.RUnlock()
+	for _, re := range services {
+		for _, reqServiceName := range re.RequiredServices {
+			p := patch{
+				Added:   []patchEntry{},
+				Removed: []patchEntry{},
+				Epoch:   r.epoch,
+				Seq:     fullPatch.Seq,
+			}
+			sendUpdate := false
+			for _, added := range fullPatch.Added {
+				if added.Name == reqServiceName {
+					p.Added = append(p.Added, added)
+					sendUpdate = true
 				}
-				for _, removed := range fullPatch.Removed {
-					if removed.Name == reqServiceName {
-						p.Removed = append(p.Removed, removed)
-						sendUpdate = true
-					}
+			}
+			for _, removed := range fullPatch.Removed {
+				if removed.Name == reqServiceName {
+					p.Removed = append(p.Removed, removed)
+					sendUpdate = true
 				}
-				if sendUpdate {
-					err := r.sendPatch(p, re.ServiceUpdateURL)
+			}
+			if sendUpdate {
+				go func(updateURL string) {
+					err := r.sendPatch(p, updateURL)
 					if err != nil {
-						log.Printf("Failed to send patch to %s: %v\n", re.ServiceUpdateURL, err)
+						log.Printf("Failed to send patch to %s: %v\n", updateURL, err)
 					}
-				}
+				}(re.ServiceUpdateURL)
 			}
-		}(entry)
+		}
 	}
 }
 
 func (r *registry) sendRequiredServices(re RegistrationEntry) error {
+	// 发送的是依赖服务的完整快照, 客户端重新注册时用它替换旧的provider
+	// 只在锁内构造快照, 发送HTTP请求时不持有锁, 避免慢服务阻塞注册列表的修改
+	p := patch{Snapshot: true, Epoch: r.epoch}
 	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-
-	var p patch
+	p.Seq = r.version
 	for _, reqService := range re.RequiredServices {
 		for _, registeredService := range r.services {
 			if registeredService.ServiceName == reqService {
 				p.Added = append(p.Added, patchEntry{
-					Name: registeredService.ServiceName,
-					URL:  registeredService.ServiceURL,
+					Name:   registeredService.ServiceName,
+					URL:    registeredService.ServiceURL,
+					Weight: registeredService.Weight,
 				})
 			}
 		}
 	}
+	r.mutex.RUnlock()
 	err := r.sendPatch(p, re.ServiceUpdateURL)
 	if err != nil {
 		log.Printf("Failed to send patch to %s: %v\n", re.ServiceUpdateURL, err)
@@ -175,13 +365,23 @@ func (r *registry) sendRequiredServices(re RegistrationEntry) error {
 	return nil
 }
 
-func (r *registry) sendPatch(p patch, url string) error {
+func (r *registry) sendPatch(p patch, url string) (err error) {
+	ctx, span := tracing.Start(context.Background(), string(registryReporter), "patch", tracing.Client)
+	span.SetAttribute("instance", url)
+	span.SetAttribute("patch", fmt.Sprintf("added=%d removed=%d snapshot=%t", len(p.Added), len(p.Removed), p.Snapshot))
+	defer func() { span.End(err) }()
 	pj, err := json.Marshal(p)
 	if err != nil {
 		log.Printf("Failed to marshal patch: %v\n", err)
 		return err
 	}
-	res, err := http.Post(url, "application/json", bytes.NewBuffer(pj))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(pj))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+	res, err := r.client.Do(req)
 	if err != nil {
 		log.Printf("Failed to send patch to %s: %v\n", url, err)
 		return err
@@ -196,20 +396,57 @@ func (r *registry) sendPatch(p patch, url string) error {
 	return nil
 }
 
-// reg var声明并实例化一个包级的registry变量
+// defaultServer var声明并实例化一个包级的默认注册中心, 供包级函数和handler类型使用
 // Attention:  := 这种声明方式称为短变量声明, 只能在局部作用域中使用, 如函数体内, if/for块内等.
-var reg = registry{
-	services: make([]RegistrationEntry, 0),
-	mutex:    &sync.RWMutex{},
+var defaultServer = NewServer(ServerOptions{})
+
+// DefaultServer 返回包级函数使用的默认注册中心
+func DefaultServer() *Server {
+	return defaultServer
 }
 
-// RegistryService 实现http.Handler接口, 用于http.Handle的第二个接口参数
+// StartHealthCheck 启动默认注册中心的健康检查
+func StartHealthCheck() {
+	defaultServer.StartHealthCheck()
+}
+
+// RegistryService 实现http.Handler接口, 用于http.Handle的第二个接口参数. 使用默认的注册中心.
 type RegistryService struct{}
 
 func (rs *RegistryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defaultServer.serveServices(w, r)
+}
+
+func (s *Server) serveServices(w http.ResponseWriter, r *http.Request) {
 	log.Println("Request to register service received.")
+	w.Header().Set(EpochHeader, s.epoch)
 
 	switch r.Method {
+	case http.MethodGet:
+		// 查询注册列表. 带watch参数时阻塞等待列表版本变化, 与RPC的List/Watch共用同一套实现
+		name := ServiceName(r.URL.Query().Get("name"))
+		var reply ListReply
+		if v := r.URL.Query().Get("watch"); v != "" {
+			version, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid watch version", http.StatusBadRequest)
+				return
+			}
+			timeout := defaultWatchTimeout
+			if t := r.URL.Query().Get("timeout"); t != "" {
+				if timeout, err = time.ParseDuration(t); err != nil {
+					http.Error(w, "Invalid watch timeout", http.StatusBadRequest)
+					return
+				}
+			}
+			reply.Version, reply.Services = s.reg.watchServices(name, version, min(timeout, maxWatchTimeout))
+		} else {
+			reply.Version, reply.Services = s.reg.listServices(name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(reply); err != nil {
+			log.Printf("Failed to encode service list: %v\n", err)
+		}
 	case http.MethodPost:
 		// 解析请求体中的字节数组注册信息
 		var entry RegistrationEntry
@@ -219,12 +456,29 @@ func (rs *RegistryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("Adding service: %+v\n", entry)
-		err = reg.addService(entry)
+		err = s.reg.addService(entry)
+		if errors.Is(err, ErrIncompatibleProtocol) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Failed to register service", http.StatusInternalServerError)
 			return
 		}
 	// 这段结束后会自动返回200 OK 并关闭连接
+	case http.MethodPut:
+		// 续约: 服务定期确认自己仍然注册在案
+		var entry RegistrationEntry
+		err := json.NewDecoder(r.Body).Decode(&entry)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err = s.reg.renewService(entry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 	case http.MethodDelete:
 		var entry RegistrationEntry
 		err := json.NewDecoder(r.Body).Decode(&entry)
@@ -233,7 +487,7 @@ func (rs *RegistryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("Removing service: %+v\n", entry)
-		err = reg.removeService(entry)
+		err = s.reg.removeService(entry)
 		if err != nil {
 			http.Error(w, "Failed to unregister service", http.StatusInternalServerError)
 			return
@@ -243,3 +497,79 @@ func (rs *RegistryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// 服务上报的健康信号, 按实例和上报者保存, 同一个上报者对同一个实例只保留最新的信号.
+type signalStore struct {
+	signals map[string]map[ServiceName]HealthSignal
+	mutex   *sync.Mutex
+}
+
+func (s *signalStore) report(sig HealthSignal) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := string(sig.ServiceName) + "@" + sig.ServiceURL
+	if _, ok := s.signals[key]; !ok {
+		s.signals[key] = make(map[ServiceName]HealthSignal)
+	}
+	s.signals[key][sig.Reporter] = sig
+	log.Printf("Health signal %s for %s at %s from %s: %s\n", sig.Level, sig.ServiceName, sig.ServiceURL, sig.Reporter, sig.Reason)
+}
+
+// active 返回没有过期的信号, 顺便清理过期的信号
+func (s *signalStore) active() []HealthSignal {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	list := make([]HealthSignal, 0)
+	for key, reporters := range s.signals {
+		for reporter, sig := range reporters {
+			if now.After(sig.Expires) {
+				delete(reporters, reporter)
+				continue
+			}
+			list = append(list, sig)
+		}
+		if len(reporters) == 0 {
+			delete(s.signals, key)
+		}
+	}
+	return list
+}
+
+// SignalService 接收服务上报的健康信号(POST), 查询当前有效的信号(GET). 使用默认的注册中心.
+type SignalService struct{}
+
+func (ss *SignalService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defaultServer.serveSignals(w, r)
+}
+
+func (s *Server) serveSignals(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.signals.active()); err != nil {
+			log.Printf("Failed to encode signals: %v\n", err)
+		}
+	case http.MethodPost:
+		var sig HealthSignal
+		if err := json.NewDecoder(r.Body).Decode(&sig); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		s.signals.report(sig)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveVersions 查询每个服务的实例版本, 用于发现滚动升级中或者升级遗漏造成的版本偏差
+func (s *Server) serveVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.reg.versions()); err != nil {
+		log.Printf("Failed to encode versions: %v\n", err)
+	}
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRegistry 在httptest服务上运行一个注册中心, 测试结束时关闭
+func newTestRegistry(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := NewServer(ServerOptions{})
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		_ = s.Close()
+	})
+	return s, srv
+}
+
+// newTestService 在httptest服务上运行一个使用client的ServeMux的服务, 返回它的注册信息
+func newTestService(t *testing.T, client *Client, name ServiceName, requires ...ServiceName) RegistrationEntry {
+	t.Helper()
+	srv := httptest.NewServer(client.Mux())
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+	return RegistrationEntry{
+		ServiceName:      name,
+		InstanceID:       string(name) + "-1",
+		ServiceURL:       srv.URL,
+		RequiredServices: requires,
+		ServiceUpdateURL: srv.URL + "/services",
+		HeartbeatURL:     srv.URL + "/health",
+	}
+}
+
+// TestIndependentServers 同一个进程中的两个注册中心和各自的客户端互不影响
+func TestIndependentServers(t *testing.T) {
+	_, reg1 := newTestRegistry(t)
+	_, reg2 := newTestRegistry(t)
+	provider := NewClient(ClientOptions{RegistryAddr: reg1.URL})
+	consumer1 := NewClient(ClientOptions{RegistryAddr: reg1.URL})
+	consumer2 := NewClient(ClientOptions{RegistryAddr: reg2.URL})
+
+	pe := newTestService(t, provider, "Provider")
+	if err := provider.Register(pe); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Client{consumer1, consumer2} {
+		// 两个客户端注册的路径相同, 各自使用自己的ServeMux, 不会panic
+		if err := c.Register(newTestService(t, c, "Consumer", "Provider")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	url, err := consumer1.WaitForProvider(ctx, "Provider")
+	if err != nil || url != pe.ServiceURL {
+		t.Fatalf("同一个注册中心的客户端应该发现Provider, 得到%q, %v", url, err)
+	}
+	if url, err := consumer2.GetProvider("Provider"); err == nil {
+		t.Fatalf("另一个注册中心的客户端不应该发现Provider, 得到%q", url)
+	}
+}
+
+// TestAddServiceReplacesEntry 同一个实例重新注册时整个替换注册信息, 健康检查也使用新的注册信息
+func TestAddServiceReplacesEntry(t *testing.T) {
+	s := NewServer(ServerOptions{HTTPClient: &http.Client{Transport: okTransport{}}})
+	defer func() { _ = s.Close() }()
+	s.health.afterFunc = newFakeTimers().afterFunc
+	old := RegistrationEntry{
+		ServiceName:      "Svc",
+		InstanceID:       "Svc-1",
+		ServiceURL:       "http://svc",
+		ServiceUpdateURL: "http://svc/services",
+		HeartbeatURL:     "http://svc/health",
+		Version:          "v1",
+	}
+	if err := s.reg.addService(old); err != nil {
+		t.Fatal(err)
+	}
+	version, _ := s.reg.listServices("Svc")
+
+	// 版本和权重不变, 依赖的服务和接口地址变了
+	re := old
+	re.RequiredServices = []ServiceName{"Provider"}
+	re.ServiceUpdateURL = "http://svc/v2/services"
+	re.HeartbeatURL = "http://svc/v2/health"
+	if err := s.reg.addService(re); err != nil {
+		t.Fatal(err)
+	}
+	got, entries := s.reg.listServices("Svc")
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0], re) {
+		t.Fatalf("重新注册后的注册信息是%+v, 期望%+v", entries, re)
+	}
+	if got <= version {
+		t.Fatalf("注册信息变化后版本号应该增加, 之前%d, 之后%d", version, got)
+	}
+	s.health.mutex.Lock()
+	tracked := s.health.targets[keyOf(re)].entry
+	s.health.mutex.Unlock()
+	if tracked.HeartbeatURL != re.HeartbeatURL {
+		t.Fatalf("健康检查使用的HeartbeatURL是%q, 期望%q", tracked.HeartbeatURL, re.HeartbeatURL)
+	}
+
+	// 注册信息完全相同时版本号不变
+	if err := s.reg.addService(re); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.reg.listServices("Svc"); again != got {
+		t.Fatalf("注册信息没有变化, 版本号从%d变成了%d", got, again)
+	}
+}
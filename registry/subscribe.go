@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 依赖服务的实例是异步到达的: 注册成功后注册中心才会推送patch, 之后实例还会随时上线下线.
+// WaitForProvider 阻塞等待依赖服务出现可用的实例; Subscribe 通过channel或者回调接收实例的增减事件.
+
+type EventType string
+
+const (
+	InstanceAdded   EventType = "added"
+	InstanceRemoved EventType = "removed"
+)
+
+// ProviderEvent 依赖服务的一个实例上线或者下线
+type ProviderEvent struct {
+	Type     EventType
+	Service  ServiceName
+	Instance Instance
+}
+
+// waitProviderPoll 所有实例都熔断时, 熔断器的状态变化不会触发通知, 按这个间隔重新尝试
+const waitProviderPoll = time.Second
+
+// subscription 一个订阅者. 事件先放进没有上限的队列, 再由单独的goroutine按顺序送进channel,
+// 这样更新provider时不会被慢的订阅者阻塞, 也不会丢失事件.
+type subscription struct {
+	events chan ProviderEvent
+	queue  []ProviderEvent
+	mutex  *sync.Mutex
+	notify chan struct{} // 队列中有新事件
+	done   chan struct{} // 取消订阅
+}
+
+func (s *subscription) push(events ...ProviderEvent) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, events...)
+	s.mutex.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) pump() {
+	defer close(s.events)
+	for {
+		s.mutex.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mutex.Unlock()
+		for _, e := range queue {
+			select {
+			case s.events <- e:
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// publish 对比更新前后的实例列表, 把增减事件发给订阅者, 并唤醒等待实例的调用方. 调用方需要持有写锁.
+func (p *providers) publish(before map[ServiceName][]Instance) {
+	changed := false
+	for name := range mergeKeys(before, p.services) {
+		events := diffInstances(name, before[name], p.services[name])
+		if len(events) == 0 {
+			continue
+		}
+		changed = true
+		for sub := range p.subscribers[name] {
+			sub.push(events...)
+		}
+	}
+	if changed {
+		close(p.changed)
+		p.changed = make(chan struct{})
+	}
+}
+
+func mergeKeys(a, b map[ServiceName][]Instance) map[ServiceName]bool {
+	keys := make(map[ServiceName]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+func diffInstances(name ServiceName, before, after []Instance) []ProviderEvent {
+	var events []ProviderEvent
+	old := make(map[string]bool, len(before))
+	for _, ins := range before {
+		old[ins.URL] = true
+	}
+	current := make(map[string]bool, len(after))
+	for _, ins := range after {
+		current[ins.URL] = true
+		if !old[ins.URL] {
+			events = append(events, ProviderEvent{Type: InstanceAdded, Service: name, Instance: ins})
+		}
+	}
+	for _, ins := range before {
+		if !current[ins.URL] {
+			events = append(events, ProviderEvent{Type: InstanceRemoved, Service: name, Instance: ins})
+		}
+	}
+	return events
+}
+
+// Subscribe 订阅依赖服务的实例变化. 订阅时已经存在的实例会先作为added事件送出, 之后按顺序送出每次增减.
+// 调用返回的cancel取消订阅, 之后channel会被关闭.
+func (c *Client) Subscribe(name ServiceName) (<-chan ProviderEvent, func()) {
+	sub := &subscription{
+		events: make(chan ProviderEvent),
+		mutex:  &sync.Mutex{},
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	c.prov.mutex.Lock()
+	if _, ok := c.prov.subscribers[name]; !ok {
+		c.prov.subscribers[name] = make(map[*subscription]bool)
+	}
+	c.prov.subscribers[name][sub] = true
+	sub.push(diffInstances(name, nil, c.prov.services[name])...)
+	c.prov.mutex.Unlock()
+	go sub.pump()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.prov.mutex.Lock()
+			delete(c.prov.subscribers[name], sub)
+			c.prov.mutex.Unlock()
+			close(sub.done)
+		})
+	}
+	return sub.events, cancel
+}
+
+// OnProviderChange 以回调的方式订阅依赖服务的实例变化, 回调在同一个goroutine中按顺序执行.
+func (c *Client) OnProviderChange(name ServiceName, fn func(ProviderEvent)) func() {
+	events, cancel := c.Subscribe(name)
+	go func() {
+		for e := range events {
+			fn(e)
+		}
+	}()
+	return cancel
+}
+
+// WaitForProvider 阻塞直到依赖服务有可用的实例, 返回选中的实例URL. ctx结束时返回ctx的错误.
+func (c *Client) WaitForProvider(ctx context.Context, name ServiceName) (string, error) {
+	for {
+		c.prov.mutex.RLock()
+		changed := c.prov.changed
+		c.prov.mutex.RUnlock()
+		url, err := c.GetProvider(name)
+		if err == nil {
+			return url, nil
+		}
+		var wait <-chan time.Time
+		if errors.Is(err, ErrCircuitOpen) {
+			wait = time.After(waitProviderPoll)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-changed:
+		case <-wait:
+		}
+	}
+}
+
+func Subscribe(name ServiceName) (<-chan ProviderEvent, func()) {
+	return defaultClient.Subscribe(name)
+}
+
+func OnProviderChange(name ServiceName, fn func(ProviderEvent)) func() {
+	return defaultClient.OnProviderChange(name, fn)
+}
+
+func WaitForProvider(ctx context.Context, name ServiceName) (string, error) {
+	return defaultClient.WaitForProvider(ctx, name)
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receive 从channel中读取一个事件, 1秒内没有事件时失败
+func receive(t *testing.T, events <-chan ProviderEvent) ProviderEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("channel已经关闭")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("1秒内没有收到事件")
+	}
+	return ProviderEvent{}
+}
+
+func TestSubscribe(t *testing.T) {
+	c := NewClient(ClientOptions{RegistryAddr: "http://registry"})
+	a := patchEntry{Name: "Svc", URL: "http://a", Weight: 1}
+	b := patchEntry{Name: "Svc", URL: "http://b", Weight: 1}
+	c.prov.Update(patch{Added: []patchEntry{a}})
+
+	events, cancel := c.Subscribe("Svc")
+	defer cancel()
+	// 订阅时已经存在的实例先作为added事件送出
+	if e := receive(t, events); e.Type != InstanceAdded || e.Instance.URL != a.URL {
+		t.Fatalf("第一个事件为%+v, 期望已经存在的实例%s上线", e, a.URL)
+	}
+	// 订阅者没有读取时更新不会被阻塞, 事件按顺序送出
+	c.prov.Update(patch{Added: []patchEntry{b}})
+	c.prov.Update(patch{Removed: []patchEntry{a}})
+	c.prov.Update(patch{Added: []patchEntry{{Name: "Other", URL: "http://other"}}})
+	want := []ProviderEvent{
+		{Type: InstanceAdded, Service: "Svc", Instance: Instance{URL: b.URL, Weight: 1}},
+		{Type: InstanceRemoved, Service: "Svc", Instance: Instance{URL: a.URL, Weight: 1}},
+	}
+	for _, w := range want {
+		if e := receive(t, events); e != w {
+			t.Fatalf("收到%+v, 期望%+v", e, w)
+		}
+	}
+
+	cancel()
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Fatalf("取消订阅后收到%+v, 其他服务的变化不应该送给订阅者", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("取消订阅后channel没有关闭")
+	}
+}
+
+func TestWaitForProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		// arrive 在等待开始后执行, 返回期望的URL
+		arrive  func(c *Client, cancel context.CancelFunc) string
+		wantErr error
+	}{
+		{
+			"等待期间实例上线",
+			func(c *Client, _ context.CancelFunc) string {
+				c.prov.Update(patch{Added: []patchEntry{{Name: "Other", URL: "http://other"}}})
+				c.prov.Update(patch{Added: []patchEntry{{Name: "Svc", URL: "http://a"}}})
+				return "http://a"
+			},
+			nil,
+		},
+		{
+			"等待期间ctx取消",
+			func(_ *Client, cancel context.CancelFunc) string {
+				cancel()
+				return ""
+			},
+			context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(ClientOptions{RegistryAddr: "http://registry"})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			type result struct {
+				url string
+				err error
+			}
+			done := make(chan result, 1)
+			go func() {
+				url, err := c.WaitForProvider(ctx, "Svc")
+				done <- result{url, err}
+			}()
+			select {
+			case r := <-done:
+				t.Fatalf("没有实例时不应该返回, 得到%+v", r)
+			case <-time.After(20 * time.Millisecond):
+			}
+			want := tt.arrive(c, cancel)
+			select {
+			case r := <-done:
+				if !errors.Is(r.err, tt.wantErr) || r.url != want {
+					t.Fatalf("得到%q, %v, 期望%q, %v", r.url, r.err, want, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("1秒内没有返回")
+			}
+		})
+	}
+}
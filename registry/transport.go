@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"DistributedGo/tracing"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Transport 通过服务发现解析请求地址的http.RoundTripper, 可以直接作为http.Client的Transport使用:
+//
+//	client := &http.Client{Transport: &registry.Transport{}}
+//	client.Get("http://GradingService/students/1")
+//
+// 请求URL的主机名是服务名时(没有端口, 不含"."且不是localhost), 每次请求都通过providers选择一个实例,
+// 把请求地址改写为该实例的地址; 其他请求原样交给Base.
+// 连接没有建立时(请求一定没有发出), 请求会换一个实例重试; 请求可能已经到达实例的错误(如连接被重置)只有幂等的请求才重试,
+// POST等请求不会被重放. 请求体都需要可以重新读取. 实例返回5xx不重试, 但会作为失败上报给熔断器.
+type Transport struct {
+	Client  *Client           // 解析服务名使用的客户端, 为nil时使用默认客户端
+	Base    http.RoundTripper // 实际发送请求的RoundTripper, 为nil时使用http.DefaultTransport
+	Retries int               // 连接失败时换实例重试的次数, 小于0表示不重试, 为0时使用默认值
+	// KeyFunc 计算请求的路由键, 供一致性哈希策略使用. 为nil时使用请求头RouteKeyHeader的值.
+	KeyFunc func(*http.Request) string
+}
+
+// RouteKeyHeader 默认的路由键请求头, 例如按学生ID路由时设置为学生ID
+const RouteKeyHeader = "X-Route-Key"
+
+const defaultTransportRetries = 2
+
+// StatusError 实例返回了5xx状态码, 上报给熔断器时作为失败处理
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s 返回状态码 %d", e.URL, e.Code)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	name, ok := serviceNameOf(req.URL)
+	if !ok {
+		return base.RoundTrip(req)
+	}
+	client := t.Client
+	if client == nil {
+		client = defaultClient
+	}
+	// 请求在一个trace中时记录一个client span, 后台任务(如发送日志)发出的请求不单独开始trace
+	if _, traced := tracing.FromContext(req.Context()); traced {
+		ctx, span := tracing.Start(req.Context(), string(client.serviceName()), req.Method+" "+string(name)+req.URL.Path, tracing.Client)
+		req = req.WithContext(ctx)
+		defer func() {
+			if res != nil {
+				span.SetAttribute("http.status", strconv.Itoa(res.StatusCode))
+				span.SetAttribute("instance", res.Request.URL.Host)
+			}
+			span.End(err)
+		}()
+	}
+	key := req.Header.Get(RouteKeyHeader)
+	if t.KeyFunc != nil {
+		key = t.KeyFunc(req)
+	}
+	retries := t.Retries
+	if retries == 0 {
+		retries = defaultTransportRetries
+	}
+	if retries < 0 || !replayable(req) {
+		retries = 0
+	}
+
+	tried := make(map[string]bool)
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		instance, done, err := client.get(name, key, true, tried)
+		if err != nil {
+			if lastErr != nil {
+				// 没有别的实例可以重试了, 返回最后一次连接失败的错误
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		tried[instance] = true
+		out, err := rewrite(req, instance, attempt)
+		if err != nil {
+			done(nil)
+			return nil, err
+		}
+		res, err := base.RoundTrip(out)
+		if err != nil {
+			done(err)
+			lastErr = err
+			if req.Context().Err() != nil || !retryable(req, err) {
+				// 调用方取消或者超时, 或者请求可能已经被实例处理, 不再重试
+				break
+			}
+			continue
+		}
+		if res.StatusCode >= http.StatusInternalServerError {
+			done(&StatusError{URL: instance, Code: res.StatusCode})
+		} else {
+			done(nil)
+		}
+		return res, nil
+	}
+	return nil, lastErr
+}
+
+// serviceNameOf 判断请求的主机名是否为服务名
+func serviceNameOf(u *url.URL) (ServiceName, bool) {
+	if u.Port() != "" || strings.Contains(u.Host, ".") || u.Hostname() == "localhost" || u.Host == "" {
+		return "", false
+	}
+	return ServiceName(u.Host), true
+}
+
+// replayable 请求体可以重新读取时才能重试
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryable 连接没有建立的错误任何请求都可以重试, 其他错误只重试幂等的请求
+func retryable(req *http.Request, err error) bool {
+	var opErr *net.OpError
+	if (errors.As(err, &opErr) && opErr.Op == "dial") || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rewrite 复制请求并把地址改写为实例的地址. RoundTripper不能修改原始请求.
+func rewrite(req *http.Request, instance string, attempt int) (*http.Request, error) {
+	target, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	out.URL.Scheme = target.Scheme
+	out.URL.Host = target.Host
+	out.URL.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+	out.URL.RawPath = ""
+	out.Host = ""
+	if id := RequestIDFrom(req.Context()); id != "" && out.Header.Get(RequestIDHeader) == "" {
+		// 把上游请求的ID传给下游服务
+		out.Header.Set(RequestIDHeader, id)
+	}
+	tracing.Inject(out.Context(), out.Header)
+	if attempt > 0 && req.GetBody != nil {
+		// 第一次请求已经读完了请求体, 重试时重新获取
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Join(errors.New("重新读取请求体失败"), err)
+		}
+		out.Body = body
+	}
+	return out, nil
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestServiceNameOf(t *testing.T) {
+	tests := []struct {
+		url  string
+		want ServiceName
+		ok   bool
+	}{
+		{"http://GradingService/students", "GradingService", true},
+		{"http://localhost/students", "", false},
+		{"http://localhost:10002/students", "", false},
+		{"http://example.com/", "", false},
+		{"http://GradingService:80/", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			got, ok := serviceNameOf(u)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("serviceNameOf(%s) = %s, %v", tt.url, got, ok)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	resetErr := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	tests := []struct {
+		method string
+		err    error
+		want   bool
+	}{
+		{http.MethodGet, dialErr, true},
+		{http.MethodPost, dialErr, true},
+		{http.MethodPost, fmt.Errorf("connect: %w", syscall.ECONNREFUSED), true},
+		{http.MethodGet, resetErr, true},
+		{http.MethodPut, resetErr, true},
+		{http.MethodPost, resetErr, false},
+		{http.MethodPatch, errors.New("EOF"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.err.Error(), func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "http://Svc/", nil)
+			if got := retryable(req, tt.err); got != tt.want {
+				t.Fatalf("retryable = %v, 期望%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayable(t *testing.T) {
+	withBody, _ := http.NewRequest(http.MethodPost, "http://Svc/", strings.NewReader("x"))
+	noGetBody, _ := http.NewRequest(http.MethodPost, "http://Svc/", strings.NewReader("x"))
+	noGetBody.GetBody = nil
+	empty, _ := http.NewRequest(http.MethodGet, "http://Svc/", nil)
+	tests := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{"没有请求体", empty, true},
+		{"可以重新读取的请求体", withBody, true},
+		{"不能重新读取的请求体", noGetBody, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replayable(tt.req); got != tt.want {
+				t.Fatalf("replayable = %v, 期望%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+)
+
+// 管理接口, 用于排查goroutine泄漏、内存和CPU问题:
+// /debug/pprof/     net/http/pprof的profile, 例如 go tool pprof http://host/debug/pprof/heap
+// /debug/goroutines 所有goroutine的调用栈
+// /debug/runtime    goroutine数量、内存和GC等运行时统计, JSON格式
+//
+// 管理接口可以放在单独的监听地址上(只在内网或本机访问), 也可以挂在服务自己的端口上, 这时必须设置token.
+
+// AdminPrefix 管理接口的路径前缀
+const AdminPrefix = "/debug/"
+
+// adminTimeout 管理接口挂在服务的端口上时的超时时间, CPU profile和trace默认采样30秒, 不能使用普通请求的超时
+const adminTimeout = 2 * time.Minute
+
+// AdminConfig 管理接口的配置
+type AdminConfig struct {
+	// Addr 管理接口单独的监听地址, 例如"localhost:6060". 为空时挂在服务自己的ServeMux上.
+	Addr string
+	// Token 不为空时请求需要带上"Authorization: Bearer <token>"请求头或者token参数. Addr为空时必须设置.
+	Token string
+}
+
+// Validate 检查配置, 管理接口不能不带认证地挂在服务的端口上
+func (c *AdminConfig) Validate() error {
+	if c.Addr == "" && c.Token == "" {
+		return errors.New("管理接口和服务使用同一个端口时必须设置token")
+	}
+	return nil
+}
+
+// AdminHandler 返回管理接口的handler, 路径以AdminPrefix开头
+func AdminHandler(token string, started time.Time) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPrefix+"pprof/", pprof.Index)
+	mux.HandleFunc(AdminPrefix+"pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc(AdminPrefix+"pprof/profile", pprof.Profile)
+	mux.HandleFunc(AdminPrefix+"pprof/symbol", pprof.Symbol)
+	mux.HandleFunc(AdminPrefix+"pprof/trace", pprof.Trace)
+	mux.HandleFunc(AdminPrefix+"goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		// debug=2 输出和panic时相同格式的完整调用栈
+		_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc(AdminPrefix+"runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(readRuntimeStats(started))
+	})
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" {
+			got = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// runtimeStats /debug/runtime返回的内容
+type runtimeStats struct {
+	Uptime       string
+	Goroutines   int
+	GOMAXPROCS   int
+	NumCPU       int
+	HeapAlloc    uint64 // 字节
+	HeapInuse    uint64
+	HeapObjects  uint64
+	TotalAlloc   uint64
+	Sys          uint64
+	NumGC        uint32
+	LastGC       time.Time `json:",omitzero"`
+	PauseTotalNs uint64
+}
+
+func readRuntimeStats(started time.Time) runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := runtimeStats{
+		Uptime:       time.Since(started).Round(time.Second).String(),
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		TotalAlloc:   m.TotalAlloc,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+	}
+	if m.LastGC != 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	return stats
+}
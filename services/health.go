@@ -0,0 +1,165 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 服务的健康检查. 各个组件在Health上注册命名的检查, 服务对外提供两个接口:
+// /health/live  存活检查, 失败说明进程已经无法恢复, 需要重启;
+// /health/ready 就绪检查, 失败说明暂时不能处理请求. 注册中心的健康检查也使用就绪检查的结果:
+// critical的实例被移出注册列表, 只影响部分功能的检查(AddWarning)失败时实例保留, 注册中心记录一个warning信号.
+// 两个接口都返回registry.HealthReport格式的JSON, 列出每一项检查的结果.
+
+const (
+	LivePath  = "/health/live"
+	ReadyPath = "/health/ready"
+
+	checkTimeout = time.Second // 每一项检查的超时时间
+)
+
+// Check 一项健康检查, 返回nil表示通过
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+	level registry.HealthLevel // 检查失败时的级别
+}
+
+// Health 服务的健康检查注册表, 零值不可用, 使用NewHealth创建
+type Health struct {
+	live  []namedCheck
+	ready []namedCheck
+	mutex *sync.RWMutex
+}
+
+func NewHealth() *Health {
+	return &Health{mutex: &sync.RWMutex{}}
+}
+
+// AddLiveness 添加存活检查, 例如检查关键的goroutine是否卡住
+func (h *Health) AddLiveness(name string, check Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.live = append(h.live, namedCheck{name: name, check: check, level: registry.HealthCritical})
+}
+
+// AddReadiness 添加就绪检查, 失败时实例不再接收请求, 例如日志文件不可写
+func (h *Health) AddReadiness(name string, check Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.ready = append(h.ready, namedCheck{name: name, check: check, level: registry.HealthCritical})
+}
+
+// AddWarning 添加只影响部分功能的就绪检查, 失败时实例仍然就绪, 报告的状态为warning.
+// 例如依赖的日志服务不可用时成绩服务改为本地输出日志, 仍然可以处理请求.
+func (h *Health) AddWarning(name string, check Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.ready = append(h.ready, namedCheck{name: name, check: check, level: registry.HealthWarning})
+}
+
+// Live 执行所有存活检查
+func (h *Health) Live(ctx context.Context) registry.HealthReport {
+	h.mutex.RLock()
+	checks := h.live
+	h.mutex.RUnlock()
+	return run(ctx, checks)
+}
+
+// Ready 执行所有就绪检查. 进程不存活时也不就绪, 所以存活检查也包含在内.
+func (h *Health) Ready(ctx context.Context) registry.HealthReport {
+	h.mutex.RLock()
+	checks := append(append([]namedCheck{}, h.live...), h.ready...)
+	h.mutex.RUnlock()
+	return run(ctx, checks)
+}
+
+// run 并发执行检查, 每一项都有超时, 汇总为最严重的级别.
+// 不理会ctx的检查超时后按失败处理, 不再等待它返回, 一项卡住的检查不会拖住整个健康检查接口.
+func run(ctx context.Context, checks []namedCheck) registry.HealthReport {
+	report := registry.HealthReport{Status: registry.HealthPassing, Checks: make([]registry.CheckResult, len(checks))}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	results := make([]chan error, len(checks))
+	for i, c := range checks {
+		results[i] = make(chan error, 1) // 超时后检查仍然可以写入并退出
+		go func() {
+			results[i] <- c.check(ctx)
+		}()
+	}
+	for i, c := range checks {
+		var err error
+		select {
+		case err = <-results[i]:
+		case <-ctx.Done():
+			err = fmt.Errorf("检查没有在%v内完成: %w", checkTimeout, ctx.Err())
+		}
+		result := registry.CheckResult{Name: c.name, Status: registry.HealthPassing}
+		if err != nil {
+			result.Status, result.Error = c.level, err.Error()
+		}
+		report.Checks[i] = result
+	}
+	for _, result := range report.Checks {
+		switch {
+		case result.Status == registry.HealthCritical:
+			report.Status = registry.HealthCritical
+		case result.Status == registry.HealthWarning && report.Status == registry.HealthPassing:
+			report.Status = registry.HealthWarning
+		}
+	}
+	return report
+}
+
+// LiveHandler 存活检查接口
+func (h *Health) LiveHandler() http.Handler {
+	return reportHandler(h.Live)
+}
+
+// ReadyHandler 就绪检查接口, 也作为注册中心健康检查的handler
+func (h *Health) ReadyHandler() http.Handler {
+	return reportHandler(h.Ready)
+}
+
+func reportHandler(check func(context.Context) registry.HealthReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := check(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status == registry.HealthCritical {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// FileWritable 检查文件可以追加写入, 文件不存在时会被创建
+func FileWritable(path string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// ProvidersPresent 检查依赖的服务都有实例. 只读取实例列表, 不选择实例, 不影响负载均衡和熔断器的状态;
+// 实例全部熔断时不算失败, 熔断器会自己恢复.
+func ProvidersPresent(client *registry.Client, names ...registry.ServiceName) Check {
+	return func(ctx context.Context) error {
+		for _, name := range names {
+			if !client.HasProvider(name) {
+				return fmt.Errorf("%s: 没有可用的实例", name)
+			}
+		}
+		return nil
+	}
+}
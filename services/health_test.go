@@ -0,0 +1,112 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthRun(t *testing.T) {
+	fail := func(context.Context) error { return errors.New("fail") }
+	pass := func(context.Context) error { return nil }
+	// stuck 不理会ctx, 永远不返回
+	stuck := func(context.Context) error { select {} }
+	tests := []struct {
+		name    string
+		setup   func(h *Health)
+		want    registry.HealthLevel
+		wantErr string // 失败的检查中应该包含的内容
+	}{
+		{"全部通过", func(h *Health) { h.AddReadiness("a", pass); h.AddWarning("b", pass) }, registry.HealthPassing, ""},
+		{"warning检查失败", func(h *Health) { h.AddReadiness("a", pass); h.AddWarning("b", fail) }, registry.HealthWarning, "b: fail"},
+		{"就绪检查失败", func(h *Health) { h.AddWarning("a", fail); h.AddReadiness("b", fail) }, registry.HealthCritical, "b: fail"},
+		{"存活检查失败", func(h *Health) { h.AddLiveness("a", fail) }, registry.HealthCritical, "a: fail"},
+		{"检查卡住", func(h *Health) { h.AddReadiness("a", pass); h.AddReadiness("stuck", stuck) }, registry.HealthCritical, "stuck: 检查没有在"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealth()
+			tt.setup(h)
+			start := time.Now()
+			report := h.Ready(context.Background())
+			if elapsed := time.Since(start); elapsed > checkTimeout+time.Second {
+				t.Fatalf("健康检查用了%v", elapsed)
+			}
+			if report.Status != tt.want || !strings.Contains(report.Failed(), tt.wantErr) {
+				t.Fatalf("状态为%s, 失败的检查: %s, 期望%s, 包含%q", report.Status, report.Failed(), tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestReadyHandlerCanceled 请求的ctx结束时, 卡住的检查不会让接口一直等待
+func TestReadyHandlerCanceled(t *testing.T) {
+	h := NewHealth()
+	h.AddReadiness("stuck", func(context.Context) error { select {} })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", ReadyPath, nil).WithContext(ctx))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(checkTimeout / 2):
+		t.Fatal("请求取消后健康检查接口仍然在等待")
+	}
+	if rec.Code != 503 {
+		t.Fatalf("状态码为%d, 期望503", rec.Code)
+	}
+}
+
+func TestProvidersPresent(t *testing.T) {
+	reg := registry.NewServer(registry.ServerOptions{})
+	defer func() { _ = reg.Close() }()
+	rs := httptest.NewServer(reg.Handler())
+	defer rs.Close()
+	register := func(name registry.ServiceName, requires ...registry.ServiceName) *registry.Client {
+		c := registry.NewClient(registry.ClientOptions{RegistryAddr: rs.URL})
+		srv := httptest.NewServer(c.Mux())
+		t.Cleanup(func() {
+			c.Close()
+			srv.Close()
+		})
+		err := c.Register(registry.RegistrationEntry{
+			ServiceName:      name,
+			ServiceURL:       srv.URL,
+			RequiredServices: requires,
+			ServiceUpdateURL: srv.URL + "/services",
+			HeartbeatURL:     srv.URL + "/health",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	register("Provider")
+	c := register("Consumer", "Provider", "Missing")
+	check := ProvidersPresent(c, "Provider")
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("Provider已经注册, 得到%v", err)
+	}
+	// 熔断后仍然算有实例
+	url, _ := c.GetProvider("Provider")
+	for range 10 {
+		c.Report("Provider", url, errors.New("fail"))
+	}
+	if _, err := c.GetProvider("Provider"); !errors.Is(err, registry.ErrCircuitOpen) {
+		t.Fatalf("连续失败后应该熔断, 得到%v", err)
+	}
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("实例全部熔断时不应该失败, 得到%v", err)
+	}
+	if err := ProvidersPresent(c, "Provider", "Missing")(context.Background()); err == nil || !strings.Contains(err.Error(), "Missing") {
+		t.Fatalf("Missing没有实例, 得到%v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// InfoPath 服务的构建和运行信息
+const InfoPath = "/info"
+
+// Version 构建版本, 发布时通过 -ldflags "-X DistributedGo/services.Version=v1.2.3" 设置.
+// 为空时使用模块的版本, go run或者go build构建时一般是"(devel)".
+var Version string
+
+// Info /info返回的内容
+type Info struct {
+	Service    string
+	InstanceID string
+	Version    string
+	Revision   string `json:",omitempty"` // VCS的提交, 由go build从仓库中读取
+	VCSTime    string `json:",omitempty"`
+	Modified   bool   `json:",omitempty"` // 构建时工作区有未提交的修改
+	GoVersion  string
+	StartTime  time.Time
+	ConfigHash string // 影响服务行为的配置的哈希, 同一个版本的实例配置不同时可以据此发现
+}
+
+// buildInfo 从debug.ReadBuildInfo读取版本和VCS信息
+func buildInfo() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.VCSTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// configHash 计算配置中影响服务行为的部分的哈希. 不包含地址和实例ID, 同一个服务的实例配置相同时哈希相同;
+// 也不包含证书内容和handler等函数.
+func configHash(cfg Config) string {
+	re := cfg.Registration
+	data, _ := json.Marshal(struct {
+		ServiceName      registry.ServiceName
+		RequiredServices []registry.ServiceName
+		Weight           int
+		LoadBalancing    map[registry.ServiceName]registry.BalancerType
+		RouteTimeouts    map[string]time.Duration
+		Tracing          bool
+		TLS              *TLSConfig
+		DrainGrace       time.Duration
+		ShutdownTimeout  time.Duration
+	}{re.ServiceName, re.RequiredServices, re.Weight, re.LoadBalancing, cfg.RouteTimeouts, cfg.Tracing, cfg.TLS, cfg.DrainGrace, cfg.ShutdownTimeout})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// infoHandler 返回服务的构建和运行信息
+func infoHandler(info Info) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+	})
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"DistributedGo/tracing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务的中间件. services.Run用中间件链包装服务的ServeMux, 每个请求依次经过:
+// 请求ID -> 追踪 -> 访问日志 -> 请求体大小限制 -> 超时 -> panic恢复 -> 服务的handler.
+// panic恢复紧挨着handler: http.TimeoutHandler在单独的goroutine中执行handler, 恢复放在它外面时,
+// panic要先穿过超时的handler, 超时后发生的panic也无法返回500.
+// 访问日志是一行JSON, 依赖日志服务的服务把访问日志发往日志服务, 其他服务(包括日志服务自己)输出到标准错误.
+
+const (
+	defaultBodyLimit      = 1 << 20 // 请求体的默认上限, 1MB
+	defaultRequestTimeout = 10 * time.Second
+)
+
+// Middleware 包装一个handler, 返回新的handler
+type Middleware func(http.Handler) http.Handler
+
+// Chain 用中间件包装handler, 第一个中间件在最外层
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// DefaultMiddleware 默认的中间件链. accessLog是访问日志的输出, routeTimeouts按路径前缀设置超时时间, 没有匹配的路径使用默认超时.
+// streamRoutes 中的路径前缀只设置ctx的截止时间, 不缓冲响应, 见Timeout.
+func DefaultMiddleware(service registry.ServiceName, accessLog *log.Logger, routeTimeouts map[string]time.Duration, streamRoutes ...string) []Middleware {
+	return []Middleware{
+		RequestID(),
+		Trace(service),
+		AccessLog(service, accessLog),
+		BodyLimit(defaultBodyLimit),
+		Timeout(defaultRequestTimeout, routeTimeouts, streamRoutes...),
+		Recover(),
+	}
+}
+
+// RequestID 沿用请求头中的请求ID, 没有时生成一个. 请求ID放进ctx并写入响应头,
+// 服务通过registry.Transport调用其他服务时会传递下去.
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(registry.RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+				r.Header.Set(registry.RequestIDHeader, id)
+			}
+			w.Header().Set(registry.RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(registry.WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Trace 从traceparent请求头中读取追踪上下文, 为每个请求记录一个server span. 5xx记录为错误.
+// 注册中心的健康检查和patch推送也带有traceparent, 它们在服务中的处理会出现在注册中心开始的trace中.
+func Trace(service registry.ServiceName) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := tracing.Extract(r.Context(), r.Header)
+			ctx, span := tracing.Start(ctx, string(service), r.Method+" "+r.URL.Path, tracing.Server)
+			rec := &statusRecorder{ResponseWriter: w}
+			var err error
+			defer func() {
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+				span.SetAttribute("http.status", strconv.Itoa(rec.status))
+				if id := registry.RequestIDFrom(ctx); id != "" {
+					span.SetAttribute("request_id", id)
+				}
+				if rec.status >= http.StatusInternalServerError {
+					err = errors.New(http.StatusText(rec.status))
+				}
+				span.End(err)
+			}()
+			next.ServeHTTP(rec, r.WithContext(ctx))
+		})
+	}
+}
+
+// statusRecorder 记录handler写出的状态码和字节数
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessRecord 一条访问日志
+type accessRecord struct {
+	Type      string  `json:"type"`
+	Service   string  `json:"service"`
+	RequestID string  `json:"request_id"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Duration  float64 `json:"duration_ms"`
+	Remote    string  `json:"remote"`
+}
+
+// AccessLog 每个请求结束后向logger输出一行JSON格式的访问日志. 注册中心的健康检查很频繁, 不记录/health开头的路径.
+// 不使用标准库log的默认输出: 它可能被设置为发往日志服务, 日志服务记录自己的访问日志时会再次请求自己.
+// logger发往日志服务时(log.NewClientLogger), 记录放进发送队列后立即返回, 不等待日志服务响应.
+func AccessLog(service registry.ServiceName, logger *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/health") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			data, _ := json.Marshal(accessRecord{
+				Type:      "access",
+				Service:   string(service),
+				RequestID: registry.RequestIDFrom(r.Context()),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    rec.status,
+				Bytes:     rec.bytes,
+				Duration:  float64(time.Since(start).Microseconds()) / 1000,
+				Remote:    r.RemoteAddr,
+			})
+			accessLogger(logger, r.Context()).Println(string(data))
+		})
+	}
+}
+
+// accessLogger 发往日志服务的Logger支持带上请求的ctx, 日志服务收到的请求和当前请求在同一个trace中
+func accessLogger(logger *log.Logger, ctx context.Context) *log.Logger {
+	if w, ok := logger.Writer().(interface {
+		WithContext(context.Context) io.Writer
+	}); ok {
+		return log.New(w.WithContext(ctx), logger.Prefix(), logger.Flags())
+	}
+	return logger
+}
+
+// Recover handler发生panic时记录调用栈并返回500, 一个请求的错误不会让整个服务退出
+func Recover() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("[%s] %s %s panic: %v\n%s", registry.RequestIDFrom(r.Context()), r.Method, r.URL.Path, p, debug.Stack())
+				if rec.status == 0 {
+					http.Error(rec, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// BodyLimit 限制请求体的大小, 超过时handler读取请求体会失败
+func BodyLimit(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout 为每个请求设置超时时间, routes按最长的路径前缀匹配. 超时后返回503, handler通过r.Context()感知超时.
+// http.TimeoutHandler缓冲整个响应, 不支持Flush. streaming中的路径前缀(日志查询、pprof这样边生成边输出或者运行很久的接口)
+// 不经过它, 只给r.Context()设置同样的截止时间, 响应直接写出, 超时后由handler自己结束.
+func Timeout(d time.Duration, routes map[string]time.Duration, streaming ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		handlers := make(map[string]http.Handler, len(routes))
+		for prefix, t := range routes {
+			handlers[prefix] = http.TimeoutHandler(next, t, "request timeout")
+		}
+		fallback := http.TimeoutHandler(next, d, "request timeout")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h, timeout, matched := fallback, d, ""
+			for prefix, t := range handlers {
+				if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(matched) {
+					h, timeout, matched = t, routes[prefix], prefix
+				}
+			}
+			if slices.ContainsFunc(streaming, func(prefix string) bool { return strings.HasPrefix(r.URL.Path, prefix) }) {
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
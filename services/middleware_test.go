@@ -0,0 +1,148 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowHandler 等待d或者ctx结束, ctx结束时返回504, 否则返回200
+func slowHandler(d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(d):
+			_, _ = w.Write([]byte("ok"))
+		case <-r.Context().Done():
+			http.Error(w, "deadline", http.StatusGatewayTimeout)
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	mw := Timeout(20*time.Millisecond, map[string]time.Duration{"/slow/": time.Second, "/stream/long/": time.Second}, "/stream/")
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/fast", http.StatusServiceUnavailable}, // 默认超时, TimeoutHandler返回503
+		{"/slow/x", http.StatusOK},               // 路由设置了更长的超时
+		{"/stream/x", http.StatusGatewayTimeout}, // 流式路由只有ctx的截止时间, 由handler自己返回
+		{"/stream/long/x", http.StatusOK},        // 流式路由同样按前缀使用更长的超时
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mw(slowHandler(100*time.Millisecond)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("状态码为%d, 期望%d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+// TestTimeoutStreaming 流式路由的响应不被缓冲, handler可以Flush, ctx带有截止时间
+func TestTimeoutStreaming(t *testing.T) {
+	var flushable, deadline bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flushable = w.(http.Flusher)
+		_, deadline = r.Context().Deadline()
+	})
+	mw := Timeout(time.Second, nil, AdminPrefix)
+	for path, want := range map[string]bool{AdminPrefix + "pprof/profile": true, "/students": false} {
+		flushable, deadline = false, false
+		mw(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		if flushable != want || !deadline {
+			t.Errorf("%s: Flusher=%t 截止时间=%t, 期望Flusher=%t", path, flushable, deadline, want)
+		}
+	}
+}
+
+// TestDefaultMiddlewarePanic panic被最内层的Recover恢复, 返回500, 外层的请求ID和访问日志照常工作
+func TestDefaultMiddlewarePanic(t *testing.T) {
+	var buf bytes.Buffer
+	chain := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}), DefaultMiddleware("Svc", log.New(&buf, "", 0), nil)...)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/students", nil)
+	req.Header.Set(registry.RequestIDHeader, "req-1")
+	chain.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("状态码为%d, 期望500", rec.Code)
+	}
+	if id := rec.Header().Get(registry.RequestIDHeader); id != "req-1" {
+		t.Fatalf("响应的请求ID为%q, 期望沿用请求头中的req-1", id)
+	}
+	var record accessRecord
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("访问日志不是JSON: %q", buf.String())
+	}
+	if record.Status != http.StatusInternalServerError || record.RequestID != "req-1" || record.Path != "/students" {
+		t.Fatalf("访问日志为%+v", record)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var fromCtx string
+	h := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromCtx = registry.RequestIDFrom(r.Context())
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	id := rec.Header().Get(registry.RequestIDHeader)
+	if id == "" || id != fromCtx {
+		t.Fatalf("生成的请求ID: 响应头%q, ctx中%q", id, fromCtx)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	h := BodyLimit(4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"没有超过", func() *http.Request { return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcd")) }, http.StatusOK},
+		{"Content-Length超过", func() *http.Request { return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcdef")) }, http.StatusRequestEntityTooLarge},
+		{"没有Content-Length", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcdef"))
+			r.ContentLength = -1
+			return r
+		}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.req())
+			if rec.Code != tt.status {
+				t.Fatalf("状态码为%d, 期望%d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+// TestAccessLogSkipsHealth 注册中心的健康检查不记录访问日志
+func TestAccessLogSkipsHealth(t *testing.T) {
+	var buf bytes.Buffer
+	h := AccessLog("Svc", log.New(&buf, "", 0))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if buf.Len() != 0 {
+		t.Fatalf("健康检查不应该记录访问日志, 得到%q", buf.String())
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/students", nil).WithContext(context.Background()))
+	if !strings.Contains(buf.String(), `"path":"/students"`) {
+		t.Fatalf("访问日志为%q", buf.String())
+	}
+}
@@ -1,66 +1,359 @@
 package services
 
 import (
+	applog "DistributedGo/log"
 	"DistributedGo/registry"
+	"DistributedGo/tracing"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"log"
 )
 
 // 这里的服务是公共服务, 供其他模块调用的
 
-// Start 启动一个http服务, 并注册处理器. 这是一个通用的服务启动函数, 所以单独放在service包中
-func Start(ctx context.Context, host, port string, re registry.RegistrationEntry, registerHandler func()) (context.Context, error) {
-	// 1. 注册处理器
-	registerHandler()
+const (
+	defaultDrainGrace      = 2 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// Config 启动服务的配置
+type Config struct {
+	// Port 为":0"时由系统分配端口. 注册信息中的ServiceURL、ServiceUpdateURL和HeartbeatURL
+	// 根据实际监听的地址生成, 见Registration.
+	Host, Port string
+	// AdvertiseAddr 注册到注册中心的地址, 例如容器外可以访问的"10.0.0.5"或"gateway.local:8080".
+	// 为空时使用Host; 没有端口时使用实际监听的端口.
+	AdvertiseAddr string
+	// Instance 实例后缀, 同一个服务启动多个实例时用来区分, 实例ID为"服务名-后缀". 为空时使用实际监听的端口.
+	Instance string
+	// Registration 注册信息. ServiceURL为空时使用AdvertiseAddr生成; ServiceUpdateURL和HeartbeatURL
+	// 为空时分别使用"/services"和"/health", 只有路径时拼接在ServiceURL后面.
+	Registration     registry.RegistrationEntry
+	RegisterHandlers func(mux *http.ServeMux) // 在服务自己的ServeMux上注册处理器
+	// Client 注册和服务发现使用的客户端, 为nil时新建一个. 每个服务使用自己的Client和ServeMux,
+	// 同一个进程中可以在不同的端口上启动多个服务, 不会因为重复的路由panic.
+	Client *registry.Client
+	// Health 服务的健康检查, 为nil时新建一个. 服务提供/health/live和/health/ready接口,
+	// 注册中心的健康检查(HeartbeatURL)返回就绪检查的结果.
+	Health *Health
+	// Middleware 包装服务handler的中间件链, 为nil时使用DefaultMiddleware. RouteTimeouts按路径前缀设置默认中间件链的超时时间.
+	Middleware    []Middleware
+	RouteTimeouts map[string]time.Duration
+	// StreamRoutes 边生成边输出或者运行很久的接口的路径前缀, 例如日志查询. 默认中间件链不用http.TimeoutHandler缓冲它们的响应,
+	// 只设置r.Context()的截止时间. 管理接口挂在服务的端口上时自动加入.
+	StreamRoutes []string
+	// Tracing 把服务记录的span发往TracingService, TracingService会自动加入依赖的服务.
+	// 服务使用自己的导出器(tracing.RegisterExporter), 服务结束前发送剩下的span并关闭它.
+	Tracing bool
+	// TLS 为nil时使用HTTP, 否则使用HTTPS, 注册的ServiceURL使用对应的scheme. 两种情况下都支持HTTP/2.
+	TLS *TLSConfig
+	// Admin 不为nil时启用管理接口(pprof、goroutine和运行时统计), 见AdminConfig
+	Admin *AdminConfig
+	// Version 服务的版本, 在/info中返回并随注册信息发给注册中心. 为空时使用构建信息, 见services.Version.
+	Version string
+	// AccessLogger 默认中间件链输出访问日志的Logger. 为nil时, 依赖日志服务的服务发往日志服务, 其他服务输出到标准错误.
+	AccessLogger *log.Logger
+
+	// 收到关闭信号后的顺序: 先注销服务, 等待DrainGrace让依赖方收到注册中心的patch, 不再发来新请求,
+	// 再调用srv.Shutdown等待进行中的请求结束, 最多等待ShutdownTimeout.
+	DrainGrace      time.Duration // 为0时使用默认值, 小于0表示不等待
+	ShutdownTimeout time.Duration // 为0时使用默认值
+	Signals         []os.Signal   // 触发关闭的信号, 为空时使用SIGINT和SIGTERM
+	IgnoreSignals   bool          // 不处理信号, 只通过ctx关闭服务. 由调用方统一协调多个服务的关闭顺序时使用
+	StdinControl    bool          // 是否允许在标准输入中输入任意内容关闭服务, 在终端中手动运行时使用
+}
+
+// Start 启动一个http服务, 并注册处理器. 这是一个通用的服务启动函数, 所以单独放在service包中.
+// 服务使用自己的ServeMux, 收到SIGINT/SIGTERM时关闭服务, 需要其他配置时使用Run.
+func Start(ctx context.Context, host, port string, re registry.RegistrationEntry, registerHandler func(mux *http.ServeMux)) (context.Context, error) {
+	return Run(ctx, Config{Host: host, Port: port, Registration: re, RegisterHandlers: registerHandler})
+}
+
+// Run 按配置启动服务, 返回的ctx在服务关闭后结束. 传入的ctx结束时服务也会按同样的顺序关闭.
+func Run(ctx context.Context, cfg Config) (context.Context, error) {
+	if cfg.DrainGrace == 0 {
+		cfg.DrainGrace = defaultDrainGrace
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if len(cfg.Signals) == 0 {
+		cfg.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if cfg.Client == nil {
+		cfg.Client = registry.NewClient(registry.ClientOptions{})
+	}
+	if cfg.Health == nil {
+		cfg.Health = NewHealth()
+	}
+	if cfg.Admin != nil {
+		if err := cfg.Admin.Validate(); err != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, err
+		}
+		if cfg.Admin.Addr == "" {
+			// 挂在服务的端口上时, profile需要比普通请求更长的超时时间
+			cfg.RouteTimeouts = maps.Clone(cfg.RouteTimeouts)
+			if cfg.RouteTimeouts == nil {
+				cfg.RouteTimeouts = make(map[string]time.Duration)
+			}
+			cfg.RouteTimeouts[AdminPrefix] = adminTimeout
+			cfg.StreamRoutes = append(slices.Clone(cfg.StreamRoutes), AdminPrefix)
+		}
+	}
+	if cfg.Middleware == nil {
+		if cfg.AccessLogger == nil {
+			cfg.AccessLogger = log.New(os.Stderr, "", log.LstdFlags)
+			if slices.Contains(cfg.Registration.RequiredServices, registry.LogService) {
+				cfg.AccessLogger = applog.NewClientLogger(cfg.Client, fmt.Sprintf("http://%s/log", registry.LogService), cfg.Registration.ServiceName)
+			}
+		}
+		cfg.Middleware = DefaultMiddleware(cfg.Registration.ServiceName, cfg.AccessLogger, cfg.RouteTimeouts, cfg.StreamRoutes...)
+	}
 
-	// 2. 启动服务
-	ctx = startService(ctx, re, host, port)
+	if cfg.Tracing {
+		if !slices.Contains(cfg.Registration.RequiredServices, registry.TracingService) {
+			cfg.Registration.RequiredServices = append(slices.Clone(cfg.Registration.RequiredServices), registry.TracingService)
+		}
+	}
+
+	// 在监听端口之前加载证书, 证书有问题时不占用端口
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		if tlsConfig, err = cfg.TLS.config(cfg.Host, advertiseHost(cfg.AdvertiseAddr)); err != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, err
+		}
+	}
+
+	// 先监听端口, 端口为":0"时才能知道实际的地址
+	listener, err := net.Listen("tcp", cfg.Host+cfg.Port)
+	if err != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx, err
+	}
+	cfg.Registration = advertise(cfg, listener.Addr())
+	info := buildInfo()
+	if cfg.Version != "" {
+		info.Version = cfg.Version
+	}
+	if cfg.Registration.Version == "" {
+		cfg.Registration.Version = info.Version
+	}
+	info.Service, info.InstanceID = string(cfg.Registration.ServiceName), cfg.Registration.InstanceID
+	info.StartTime, info.ConfigHash = time.Now(), configHash(cfg)
+
+	// 1. 注册处理器, 注册服务时客户端的handler也会添加在同一个ServeMux上
+	mux := cfg.Client.Mux()
+	cfg.RegisterHandlers(mux)
+	mux.Handle(LivePath, cfg.Health.LiveHandler())
+	mux.Handle(ReadyPath, cfg.Health.ReadyHandler())
+	mux.Handle(InfoPath, infoHandler(info))
+	var admin *http.Server
+	if cfg.Admin != nil {
+		handler := AdminHandler(cfg.Admin.Token, info.StartTime)
+		if cfg.Admin.Addr == "" {
+			mux.Handle(AdminPrefix, handler)
+		} else if admin, err = StartAdmin(cfg.Admin.Addr, handler); err != nil {
+			_ = listener.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, err
+		}
+	}
+	cfg.Client.SetHeartbeat(cfg.Health.ReadyHandler())
+
+	// 2. 启动服务. 服务持有自己的span导出器, 同一个进程中的其他服务不会替换它; 服务结束前发送队列中剩下的span
+	closeTracing := func() {}
+	if cfg.Tracing {
+		client := cfg.Client
+		exporter := tracing.NewHTTPExporter(func() (string, error) {
+			return client.GetProvider(registry.TracingService)
+		}, func(url string, err error) {
+			client.Report(registry.TracingService, url, err)
+		})
+		unregister := tracing.RegisterExporter(string(cfg.Registration.ServiceName), exporter)
+		closeTracing = func() {
+			unregister()
+			exporter.Close()
+		}
+	}
+	ctx = startService(ctx, cfg, listener, tlsConfig, closeTracing)
+	if admin != nil {
+		go func() {
+			<-ctx.Done()
+			_ = admin.Close()
+		}()
+	}
 
 	// 3. 注册服务
-	if err := registry.RegisterService(re); err != nil {
+	if err := cfg.Client.Register(cfg.Registration); err != nil {
 		return ctx, err
 	}
 
 	return ctx, nil
 }
 
-func startService(ctx context.Context, re registry.RegistrationEntry, host string, port string) context.Context {
+// advertise 根据实际监听的地址补全注册信息
+func advertise(cfg Config, addr net.Addr) registry.RegistrationEntry {
+	re := cfg.Registration
+	_, port, _ := net.SplitHostPort(addr.String())
+	if re.ServiceURL == "" {
+		host := cfg.AdvertiseAddr
+		if host == "" {
+			host = cfg.Host
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+		scheme := "http"
+		if cfg.TLS != nil {
+			scheme = "https"
+		}
+		re.ServiceURL = scheme + "://" + host
+	}
+	if re.ServiceUpdateURL == "" {
+		re.ServiceUpdateURL = "/services"
+	}
+	if strings.HasPrefix(re.ServiceUpdateURL, "/") {
+		re.ServiceUpdateURL = re.ServiceURL + re.ServiceUpdateURL
+	}
+	if re.HeartbeatURL == "" {
+		re.HeartbeatURL = "/health"
+	}
+	if strings.HasPrefix(re.HeartbeatURL, "/") {
+		re.HeartbeatURL = re.ServiceURL + re.HeartbeatURL
+	}
+	if re.InstanceID == "" {
+		suffix := cfg.Instance
+		if suffix == "" {
+			suffix = port
+		}
+		re.InstanceID = fmt.Sprintf("%s-%s", re.ServiceName, suffix)
+	}
+	return re
+}
+
+// StartAdmin 在单独的地址上启动管理接口, 不经过服务的中间件. 调用方负责关闭返回的http.Server.
+func StartAdmin(addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("管理接口监听失败: %w", err)
+	}
+	srv := &http.Server{Handler: handler}
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
+	fmt.Printf("管理接口已启动, 监听地址%s\n", listener.Addr())
+	return srv, nil
+}
+
+// advertiseHost 返回注册地址中的主机名, 用于生成自签名证书
+func advertiseHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// startService 启动HTTP服务并管理它的生命周期, cleanup在服务结束、返回的ctx取消之前调用
+func startService(ctx context.Context, cfg Config, listener net.Listener, tlsConfig *tls.Config, cleanup func()) context.Context {
 	// 因为后面需要启动两个goroutine来管理服务的生成周期, 所以使用可需要的ctx. 应该是一个典型的应用场景
 	// 返回这个可取消的ctx, 让调用方可以等待服务的结束
-	ctx, cancel := context.WithCancel(ctx)
+	re, client := cfg.Registration, cfg.Client
+	var stopCtx context.Context
+	var stop context.CancelFunc
+	if cfg.IgnoreSignals {
+		stopCtx, stop = context.WithCancel(ctx)
+	} else {
+		stopCtx, stop = signal.NotifyContext(ctx, cfg.Signals...)
+	}
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancel := sync.OnceFunc(func() {
+		cleanup()
+		cancelCtx()
+	})
 	var srv http.Server
-	srv.Addr = host + port
+	srv.Handler = Chain(client.Mux(), cfg.Middleware...)
+	srv.TLSConfig = tlsConfig
+	srv.Protocols = protocols(tlsConfig != nil)
 
 	// 1. 启动该服务, 如果启动失败, 直接结束该服务
 	go func() {
-		log.Println(srv.ListenAndServe()) // 启动失败, 直接结束该服务
-		err := registry.DeregisterService(re)
-		if err != nil {
-			log.Println(err)
-			// 这里不要 return
+		var err error
+		if tlsConfig != nil {
+			err = srv.ServeTLS(listener, "", "") // 证书已经在TLSConfig中
+		} else {
+			err = srv.Serve(listener)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err) // 启动失败, 直接结束该服务
+			if err := client.Deregister(re); err != nil {
+				log.Println(err)
+				// 这里不要 return
+			}
+			cancel()
+			stop()
 		}
-		cancel()
 	}()
 
-	// 2. 手动关闭该服务
+	// 2. 标准输入控制是可选的, 在systemd、测试或者后台任务中标准输入不可用
+	fmt.Printf("服务[%s]已启动, 监听地址%s, 注册地址%s\n", re.InstanceID, listener.Addr(), re.ServiceURL)
+	if cfg.StdinControl {
+		go func() {
+			fmt.Printf("按任意键退出[%s]服务...\n", re.InstanceID)
+			var s string
+			_, _ = fmt.Scan(&s)
+			stop()
+		}()
+	}
+
+	// 3. 收到信号后关闭该服务
 	go func() {
-		fmt.Printf("服务[%s]已启动, 监听地址%s%s\n", re.ServiceName, host, port)
-		fmt.Printf("按任意键退出[%s]服务...\n", re.ServiceName)
-		var s string
-		_, _ = fmt.Scan(&s)
-		fmt.Printf("正在关闭服务[%s]...\n", re.ServiceName)
+		<-stopCtx.Done()
+		stop()
+		if ctx.Err() != nil {
+			// 服务启动失败, 已经结束
+			return
+		}
+		fmt.Printf("正在关闭服务[%s]...\n", re.InstanceID)
 		// 注销服务. 先注销服务, 再关闭服务
-		err := registry.DeregisterService(re)
-		if err != nil {
+		if err := client.Deregister(re); err != nil {
 			log.Println(err)
 			// 这里不要 return
 		}
-		_ = srv.Shutdown(ctx)
+		if cfg.DrainGrace > 0 {
+			time.Sleep(cfg.DrainGrace)
+		}
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("服务[%s]没有在%v内关闭: %v\n", re.InstanceID, cfg.ShutdownTimeout, err)
+			_ = srv.Close()
+		}
+		cancelShutdown()
 		cancel()
-
 	}()
 
 	return ctx
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdvertise(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4zero, Port: 12345}
+	tests := []struct {
+		name     string
+		cfg      Config
+		wantURL  string
+		wantID   string
+		wantBeat string
+	}{
+		{"没有主机", Config{}, "http://localhost:12345", "Svc-12345", "http://localhost:12345/health"},
+		{"0.0.0.0", Config{Host: "0.0.0.0"}, "http://localhost:12345", "Svc-12345", ""},
+		{"IPv6通配地址", Config{Host: "::"}, "http://localhost:12345", "Svc-12345", ""},
+		{"IPv6地址", Config{Host: "::1"}, "http://[::1]:12345", "Svc-12345", ""},
+		{"主机名", Config{Host: "grading.local"}, "http://grading.local:12345", "Svc-12345", ""},
+		{"AdvertiseAddr优先于Host", Config{Host: "0.0.0.0", AdvertiseAddr: "10.0.0.5"}, "http://10.0.0.5:12345", "Svc-12345", ""},
+		{"AdvertiseAddr带端口", Config{AdvertiseAddr: "gateway.local:8080"}, "http://gateway.local:8080", "Svc-12345", ""},
+		{"AdvertiseAddr是IPv6地址", Config{AdvertiseAddr: "fe80::1"}, "http://[fe80::1]:12345", "Svc-12345", ""},
+		{"AdvertiseAddr是带括号的IPv6地址", Config{AdvertiseAddr: "[fe80::1]"}, "http://[fe80::1]:12345", "Svc-12345", ""},
+		{"AdvertiseAddr是带端口的IPv6地址", Config{AdvertiseAddr: "[fe80::1]:9000"}, "http://[fe80::1]:9000", "Svc-12345", ""},
+		{"HTTPS", Config{TLS: &TLSConfig{SelfSigned: true}}, "https://localhost:12345", "Svc-12345", ""},
+		{"实例后缀", Config{Instance: "2"}, "http://localhost:12345", "Svc-2", ""},
+		{
+			"已经指定的注册信息",
+			Config{Registration: registry.RegistrationEntry{ServiceURL: "http://svc", InstanceID: "svc-a", HeartbeatURL: "http://other/health"}},
+			"http://svc", "svc-a", "http://other/health",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Registration.ServiceName = "Svc"
+			re := advertise(tt.cfg, addr)
+			if re.ServiceURL != tt.wantURL || re.InstanceID != tt.wantID {
+				t.Fatalf("ServiceURL=%q InstanceID=%q, 期望%q %q", re.ServiceURL, re.InstanceID, tt.wantURL, tt.wantID)
+			}
+			wantBeat := tt.wantBeat
+			if wantBeat == "" {
+				wantBeat = tt.wantURL + "/health"
+			}
+			if re.HeartbeatURL != wantBeat || re.ServiceUpdateURL != tt.wantURL+"/services" {
+				t.Fatalf("HeartbeatURL=%q ServiceUpdateURL=%q", re.HeartbeatURL, re.ServiceUpdateURL)
+			}
+		})
+	}
+}
+
+// TestRunServesBeforeRegister 注册中心在处理注册请求时就访问服务, Run必须在注册之前开始接受连接
+func TestRunServesBeforeRegister(t *testing.T) {
+	// 不复用连接: 复用时Transport可能多拨出一个没有用到的连接, srv.Shutdown要等它空闲5秒才会关闭
+	noKeepAlive := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	reg := registry.NewServer(registry.ServerOptions{HTTPClient: noKeepAlive})
+	defer func() { _ = reg.Close() }()
+	probed := make(chan int, 1)
+	rs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/services" {
+			body, _ := io.ReadAll(r.Body)
+			var re registry.RegistrationEntry
+			_ = json.Unmarshal(body, &re)
+			status := 0
+			if res, err := noKeepAlive.Get(re.ServiceURL + LivePath); err == nil {
+				status = res.StatusCode
+				_ = res.Body.Close()
+			}
+			probed <- status
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		reg.Handler().ServeHTTP(w, r)
+	}))
+	defer rs.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := Run(ctx, Config{
+		Host:             "127.0.0.1",
+		Port:             ":0",
+		Registration:     registry.RegistrationEntry{ServiceName: "Svc"},
+		RegisterHandlers: func(*http.ServeMux) {},
+		Client:           registry.NewClient(registry.ClientOptions{RegistryAddr: rs.URL}),
+		AccessLogger:     log.New(io.Discard, "", 0),
+		IgnoreSignals:    true,
+		DrainGrace:       -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := <-probed; status != http.StatusOK {
+		t.Fatalf("注册时访问服务得到状态码%d, 期望200", status)
+	}
+	cancel()
+	select {
+	case <-done.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("ctx取消后服务没有关闭")
+	}
+}
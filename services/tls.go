@@ -0,0 +1,100 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TLSConfig 服务使用HTTPS时的证书配置. 使用TLS时通过ALPN协商HTTP/2, 不使用TLS时支持h2c(明文HTTP/2),
+// 两种情况下HTTP/1.1都可以继续使用. 注册的ServiceURL使用对应的scheme, 调用方按注册信息选择协议.
+type TLSConfig struct {
+	CertFile, KeyFile string
+	// SelfSigned 启动时生成自签名证书, 只在开发中使用. 调用方需要调用InsecureSkipVerify才能访问.
+	SelfSigned bool
+}
+
+// config 加载或生成证书. hosts是自签名证书中包含的主机名或IP
+func (c *TLSConfig) config(hosts ...string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case c.CertFile != "" && c.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	case c.SelfSigned:
+		cert, err = selfSignedCertificate(hosts...)
+	default:
+		err = errors.New("TLS需要同时指定证书和私钥文件, 或者使用自签名证书")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// selfSignedCertificate 生成一个有效期一年的自签名证书, 总是包含localhost和回环地址
+func selfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"DistributedGo development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range append(hosts, "localhost", "127.0.0.1", "::1") {
+		if h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// protocols 服务支持的协议. HTTP/2在TLS中通过ALPN协商, 明文时使用h2c(客户端直接发送HTTP/2的连接前言)
+func protocols(useTLS bool) *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	if useTLS {
+		p.SetHTTP2(true)
+	} else {
+		p.SetUnencryptedHTTP2(true)
+	}
+	return &p
+}
+
+// InsecureSkipVerify 让进程中使用http.DefaultTransport的客户端(注册中心的健康检查和推送、registry.Transport、
+// 日志和span的发送等)不校验服务端证书, 这样才能访问使用自签名证书的服务. 只在开发中使用, 需要在发出请求之前调用.
+func InsecureSkipVerify() {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"DistributedGo/registry"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runService 通过Run启动一个服务, 返回注册时上报的ServiceURL. 测试结束时关闭服务
+func runService(t *testing.T, cfg *TLSConfig) string {
+	t.Helper()
+	// 注册中心向服务推送patch时不校验自签名证书; 不复用连接, 避免空闲连接拖慢服务关闭
+	insecure := &http.Transport{DisableKeepAlives: true, TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	reg := registry.NewServer(registry.ServerOptions{HTTPClient: &http.Client{Transport: insecure}})
+	t.Cleanup(func() { _ = reg.Close() })
+	registered := make(chan string, 1)
+	rs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/services" {
+			body, _ := io.ReadAll(r.Body)
+			var re registry.RegistrationEntry
+			_ = json.Unmarshal(body, &re)
+			registered <- re.ServiceURL
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		reg.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(rs.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := Run(ctx, Config{
+		Host:             "127.0.0.1",
+		Port:             ":0",
+		TLS:              cfg,
+		Registration:     registry.RegistrationEntry{ServiceName: "Svc"},
+		RegisterHandlers: func(*http.ServeMux) {},
+		Client:           registry.NewClient(registry.ClientOptions{RegistryAddr: rs.URL}),
+		AccessLogger:     log.New(io.Discard, "", 0),
+		IgnoreSignals:    true,
+		DrainGrace:       -1,
+	})
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done.Done():
+		case <-time.After(10 * time.Second):
+			t.Error("ctx取消后服务没有关闭")
+		}
+	})
+	return <-registered
+}
+
+// TestProtocols 不使用TLS时支持h2c, 使用TLS时通过ALPN协商HTTP/2, 两种情况下HTTP/1.1都可以继续使用
+func TestProtocols(t *testing.T) {
+	tests := []struct {
+		name   string
+		tls    *TLSConfig
+		scheme string
+	}{
+		{"h2c", nil, "http://"},
+		{"TLS", &TLSConfig{SelfSigned: true}, "https://"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := runService(t, tt.tls)
+			if !strings.HasPrefix(url, tt.scheme) {
+				t.Fatalf("注册的ServiceURL是%q, 期望以%s开头", url, tt.scheme)
+			}
+			for _, major := range []int{2, 1} {
+				var p http.Protocols
+				switch {
+				case major == 1:
+					p.SetHTTP1(true)
+				case tt.tls != nil:
+					p.SetHTTP2(true)
+				default:
+					p.SetUnencryptedHTTP2(true)
+				}
+				// 自签名证书不做校验; 客户端只启用一种协议, 服务端不支持时请求失败
+				transport := &http.Transport{Protocols: &p, TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+				res, err := (&http.Client{Transport: transport}).Get(url + LivePath)
+				if err != nil {
+					t.Fatalf("HTTP/%d: %v", major, err)
+				}
+				_ = res.Body.Close()
+				transport.CloseIdleConnections() // 不留下空闲连接拖慢服务关闭
+				if res.StatusCode != http.StatusOK || res.ProtoMajor != major {
+					t.Fatalf("HTTP/%d: 得到%s %d", major, res.Proto, res.StatusCode)
+				}
+				if (res.TLS != nil) != (tt.tls != nil) {
+					t.Fatalf("HTTP/%d: 连接是否使用TLS与配置不一致", major)
+				}
+			}
+		})
+	}
+}
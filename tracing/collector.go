@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector TracingService的存储. span按trace ID保存在内存中, 超过上限时丢弃最早的trace.
+// 接口:
+// POST /spans        接收一批span
+// GET  /traces       最近的trace列表
+// GET  /traces/{id}  一个trace的瀑布图JSON, span按调用关系和开始时间排列
+
+const maxTraces = 1000
+
+type Collector struct {
+	traces map[string][]Span
+	order  []string // trace ID按第一次收到的顺序排列, 用于淘汰
+	mutex  *sync.RWMutex
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		traces: make(map[string][]Span),
+		mutex:  &sync.RWMutex{},
+	}
+}
+
+// Add 保存一批span
+func (c *Collector) Add(spans []Span) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, span := range spans {
+		if span.TraceID == "" {
+			continue
+		}
+		if _, ok := c.traces[span.TraceID]; !ok {
+			c.order = append(c.order, span.TraceID)
+			if len(c.order) > maxTraces {
+				delete(c.traces, c.order[0])
+				c.order = c.order[1:]
+			}
+		}
+		c.traces[span.TraceID] = append(c.traces[span.TraceID], span)
+	}
+}
+
+// TraceSummary 最近的trace列表中的一项
+type TraceSummary struct {
+	TraceID  string
+	Root     string // 根span的服务和名称
+	Start    time.Time
+	Duration time.Duration
+	Spans    int
+}
+
+// WaterfallSpan 瀑布图中的一行. Offset是相对于trace开始的时间, Depth是在调用树中的深度, 另外以毫秒表示一遍便于阅读.
+type WaterfallSpan struct {
+	Span
+	Offset     time.Duration
+	Depth      int
+	OffsetMs   float64
+	DurationMs float64
+}
+
+// Waterfall 一个trace的瀑布图
+type Waterfall struct {
+	TraceID  string
+	Start    time.Time
+	Duration time.Duration
+	Spans    []WaterfallSpan
+}
+
+// Traces 最近的trace, 新的在前
+func (c *Collector) Traces() []TraceSummary {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	list := make([]TraceSummary, 0, len(c.order))
+	for i := len(c.order) - 1; i >= 0; i-- {
+		w := waterfall(c.order[i], c.traces[c.order[i]])
+		summary := TraceSummary{TraceID: w.TraceID, Start: w.Start, Duration: w.Duration, Spans: len(w.Spans)}
+		if len(w.Spans) > 0 {
+			summary.Root = w.Spans[0].Service + " " + w.Spans[0].Name
+		}
+		list = append(list, summary)
+	}
+	return list
+}
+
+// Trace 返回一个trace的瀑布图
+func (c *Collector) Trace(id string) (Waterfall, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	spans, ok := c.traces[id]
+	if !ok {
+		return Waterfall{}, false
+	}
+	return waterfall(id, spans), true
+}
+
+// waterfall 按调用树深度优先排列span, 同一个parent的子span按开始时间排序.
+// parent没有收到的span(例如调用方没有接入追踪)作为根.
+func waterfall(id string, spans []Span) Waterfall {
+	w := Waterfall{TraceID: id, Spans: make([]WaterfallSpan, 0, len(spans))}
+	if len(spans) == 0 {
+		return w
+	}
+	sorted := append([]Span(nil), spans...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	w.Start = sorted[0].Start
+	known := make(map[string]bool, len(sorted))
+	for _, s := range sorted {
+		known[s.SpanID] = true
+	}
+	children := make(map[string][]Span)
+	var roots []Span
+	for _, s := range sorted {
+		if s.ParentID == "" || !known[s.ParentID] {
+			roots = append(roots, s)
+		} else {
+			children[s.ParentID] = append(children[s.ParentID], s)
+		}
+	}
+	var end time.Time
+	var visit func(s Span, depth int)
+	visit = func(s Span, depth int) {
+		offset := s.Start.Sub(w.Start)
+		w.Spans = append(w.Spans, WaterfallSpan{
+			Span:       s,
+			Offset:     offset,
+			Depth:      depth,
+			OffsetMs:   float64(offset.Microseconds()) / 1000,
+			DurationMs: float64(s.Duration.Microseconds()) / 1000,
+		})
+		if e := s.Start.Add(s.Duration); e.After(end) {
+			end = e
+		}
+		for _, child := range children[s.SpanID] {
+			visit(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		visit(root, 0)
+	}
+	w.Duration = end.Sub(w.Start)
+	return w
+}
+
+// RegisterHandlers 在服务的ServeMux上注册TracingService的接口
+func (c *Collector) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(SpansPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var spans []Span
+		if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		c.Add(spans)
+	})
+	mux.HandleFunc("/traces", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.Traces())
+	})
+	mux.HandleFunc("/traces/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/traces/")
+		trace, ok := c.Trace(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, trace)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPExporter 把span攒成一批发给TracingService. span先放进有上限的队列, 队列满时直接丢弃,
+// 追踪数据丢失不应该影响服务本身.
+
+const (
+	exportQueueSize = 4096
+	exportBatchSize = 100
+	exportInterval  = time.Second
+	exportTimeout   = 2 * time.Second
+)
+
+// SpansPath TracingService接收span的路径
+const SpansPath = "/spans"
+
+type HTTPExporter struct {
+	resolve func() (string, error) // 返回TracingService接收span的URL, 每次发送前解析, 实例变化时自动跟随
+	report  func(url string, err error)
+	client  *http.Client
+	queue   chan Span
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewHTTPExporter 创建导出器并启动发送的goroutine. resolve返回TracingService的地址, 例如通过服务发现选择一个实例.
+// report不为nil时, 每次发送后上报resolve返回的地址和发送结果, 例如交给服务发现客户端的熔断器统计.
+func NewHTTPExporter(resolve func() (string, error), report func(url string, err error)) *HTTPExporter {
+	e := &HTTPExporter{
+		resolve: resolve,
+		report:  report,
+		client:  &http.Client{Timeout: exportTimeout},
+		queue:   make(chan Span, exportQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *HTTPExporter) Export(span Span) {
+	select {
+	case e.queue <- span:
+	default:
+		// 队列满了, 丢弃
+	}
+}
+
+// Close 发送队列中剩下的span后停止
+func (e *HTTPExporter) Close() {
+	e.once.Do(func() {
+		close(e.done)
+		<-e.stopped
+	})
+}
+
+func (e *HTTPExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]Span, 0, exportBatchSize)
+	for {
+		select {
+		case span := <-e.queue:
+			if batch = append(batch, span); len(batch) >= exportBatchSize {
+				batch = e.send(batch)
+			}
+		case <-ticker.C:
+			batch = e.send(batch)
+		case <-e.done:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					e.send(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send 发送一批span, 返回清空后的batch. 发送失败时丢弃这一批.
+func (e *HTTPExporter) send(batch []Span) []Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	defer func() {
+		clear(batch)
+	}()
+	url, err := e.resolve()
+	if err != nil {
+		// TracingService没有启动时不输出日志, 避免刷屏
+		return batch[:0]
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		log.Printf("span序列化失败: %v\n", err)
+		return batch[:0]
+	}
+	res, err := e.client.Post(url+SpansPath, "application/json", bytes.NewReader(data))
+	if err == nil {
+		_ = res.Body.Close()
+		if res.StatusCode >= http.StatusInternalServerError {
+			err = fmt.Errorf("%s 返回状态码 %d", url, res.StatusCode)
+		}
+	}
+	if e.report != nil {
+		e.report(url, err)
+	}
+	if err != nil {
+		log.Printf("发送span失败: %v\n", err)
+	}
+	return batch[:0]
+}
@@ -0,0 +1,219 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 分布式追踪. 一次请求经过的每个服务、每次调用都记录为一个span, 同一次请求的span有相同的trace ID,
+// 通过parent ID连成一棵树. 服务之间按W3C Trace Context的traceparent请求头传递trace ID和上一级的span ID:
+//
+//	traceparent: 00-<32位十六进制trace ID>-<16位十六进制span ID>-01
+//
+// span结束时按Span.Service交给这个服务注册的导出器: 服务启动时通过RegisterExporter注册自己的HTTPExporter,
+// 把span发给TracingService, 关闭时取消注册并关闭它. 没有注册导出器的服务使用默认的导出器, 默认丢弃所有span.
+// 这个包不依赖registry, 由registry和services在各自的HTTP客户端和handler中使用它.
+
+// TraceparentHeader W3C Trace Context传递追踪上下文的请求头
+const TraceparentHeader = "traceparent"
+
+type Kind string
+
+const (
+	Server Kind = "server" // 处理收到的请求
+	Client Kind = "client" // 发出的请求
+)
+
+// SpanContext 跨服务传递的追踪上下文
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// Valid trace ID和span ID都不为空
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+// Traceparent 返回traceparent请求头的值
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// Parse 解析traceparent请求头, 格式不对时返回false
+func Parse(traceparent string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if parts[0] == "ff" || !isHex(parts[1]) || !isHex(parts[2]) ||
+		parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return SpanContext{}, false
+	}
+	return SpanContext{TraceID: parts[1], SpanID: parts[2]}, true
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+type spanContextKey struct{}
+
+// ContextWith 返回带有追踪上下文的ctx
+func ContextWith(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// FromContext 返回ctx中的追踪上下文
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.Valid()
+}
+
+// Extract 从收到的请求头中读取追踪上下文, 返回带有它的ctx
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := Parse(h.Get(TraceparentHeader)); ok {
+		return ContextWith(ctx, sc)
+	}
+	return ctx
+}
+
+// Inject 把ctx中的追踪上下文写入要发出的请求头
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := FromContext(ctx); ok {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Span 一次操作的记录, 也是发给TracingService的格式
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string `json:",omitempty"`
+	Service    string
+	Name       string
+	Kind       Kind
+	Start      time.Time
+	Duration   time.Duration
+	Error      string            `json:",omitempty"`
+	Attributes map[string]string `json:",omitempty"`
+}
+
+// ActiveSpan 进行中的span, 调用End后交给导出器
+type ActiveSpan struct {
+	span  Span
+	mutex *sync.Mutex
+	ended bool
+}
+
+// Start 开始一个span. ctx中有追踪上下文时作为它的子span, 否则开始一个新的trace.
+// 返回的ctx带有新span的上下文, 之后发出的请求以它为parent.
+func Start(ctx context.Context, service, name string, kind Kind) (context.Context, *ActiveSpan) {
+	span := Span{SpanID: newID(8), Service: service, Name: name, Kind: kind, Start: time.Now()}
+	if parent, ok := FromContext(ctx); ok {
+		span.TraceID, span.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		span.TraceID = newID(16)
+	}
+	ctx = ContextWith(ctx, SpanContext{TraceID: span.TraceID, SpanID: span.SpanID})
+	return ctx, &ActiveSpan{span: span, mutex: &sync.Mutex{}}
+}
+
+// SetAttribute 记录一个属性, 例如HTTP状态码
+func (s *ActiveSpan) SetAttribute(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.span.Attributes == nil {
+		s.span.Attributes = make(map[string]string)
+	}
+	s.span.Attributes[key] = value
+}
+
+// End 结束span, err不为nil时记录为错误. 多次调用只有第一次有效.
+func (s *ActiveSpan) End(err error) {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.span.Duration = time.Since(s.span.Start)
+	if err != nil {
+		s.span.Error = err.Error()
+	}
+	span := s.span
+	s.mutex.Unlock()
+	exporterFor(span.Service).Export(span)
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Exporter 接收结束的span. Export不能阻塞调用方.
+type Exporter interface {
+	Export(Span)
+}
+
+type nopExporter struct{}
+
+func (nopExporter) Export(Span) {}
+
+// registeredExporter 一次RegisterExporter注册的导出器, 取消注册时按指针删除
+type registeredExporter struct {
+	Exporter
+}
+
+var (
+	exporter      Exporter = nopExporter{}
+	exporters              = make(map[string][]*registeredExporter) // 按服务名注册的导出器, 同一个服务注册多次时使用最后注册的
+	exporterMutex          = &sync.RWMutex{}
+)
+
+// SetExporter 设置默认的导出器, 没有通过RegisterExporter注册导出器的服务记录的span交给它.
+// 被替换的导出器不会被关闭, 由设置它的调用方负责.
+func SetExporter(e Exporter) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	if e == nil {
+		e = nopExporter{}
+	}
+	exporter = e
+}
+
+// RegisterExporter 为服务注册导出器, Span.Service为service的span交给e. 同一个进程中运行多个服务时,
+// 每个服务持有自己的导出器. 服务关闭时调用返回的unregister, 然后关闭自己的导出器.
+func RegisterExporter(service string, e Exporter) (unregister func()) {
+	entry := &registeredExporter{Exporter: e}
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	exporters[service] = append(exporters[service], entry)
+	return sync.OnceFunc(func() {
+		exporterMutex.Lock()
+		defer exporterMutex.Unlock()
+		exporters[service] = slices.DeleteFunc(exporters[service], func(r *registeredExporter) bool { return r == entry })
+		if len(exporters[service]) == 0 {
+			delete(exporters, service)
+		}
+	})
+}
+
+// exporterFor 返回服务最后注册的导出器, 没有时返回默认的导出器
+func exporterFor(service string) Exporter {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	if registered := exporters[service]; len(registered) > 0 {
+		return registered[len(registered)-1]
+	}
+	return exporter
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const trace, span = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{"合法", "00-" + trace + "-" + span + "-01", true},
+		{"前后有空格", " 00-" + trace + "-" + span + "-01 ", true},
+		{"未来的版本", "01-" + trace + "-" + span + "-00", true},
+		{"非法版本ff", "ff-" + trace + "-" + span + "-01", false},
+		{"段数不对", "00-" + trace + "-" + span, false},
+		{"trace ID长度不对", "00-" + trace[1:] + "-" + span + "-01", false},
+		{"大写", "00-" + "4BF92F3577B34DA6A3CE929D0E0E4736" + "-" + span + "-01", false},
+		{"不是十六进制", "00-" + "zz" + trace[2:] + "-" + span + "-01", false},
+		{"全零trace ID", "00-00000000000000000000000000000000-" + span + "-01", false},
+		{"全零span ID", "00-" + trace + "-0000000000000000-01", false},
+		{"空", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := Parse(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("Parse(%q) = %v, 期望%v", tt.header, ok, tt.ok)
+			}
+			if ok && (sc.TraceID != trace || sc.SpanID != span) {
+				t.Fatalf("解析结果%+v", sc)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := SpanContext{TraceID: newID(16), SpanID: newID(8)}
+	got, ok := Parse(sc.Traceparent())
+	if !ok || got != sc {
+		t.Fatalf("Parse(%s) = %+v, %v", sc.Traceparent(), got, ok)
+	}
+}
+
+// recorder 记录收到的span的服务名
+type recorder struct {
+	mutex    *sync.Mutex
+	services []string
+}
+
+func (r *recorder) Export(span Span) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.services = append(r.services, span.Service)
+}
+
+func (r *recorder) got() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.services)
+}
+
+func TestRegisterExporter(t *testing.T) {
+	a := &recorder{mutex: &sync.Mutex{}}
+	b := &recorder{mutex: &sync.Mutex{}}
+	b2 := &recorder{mutex: &sync.Mutex{}}
+	unregisterA := RegisterExporter("A", a)
+	unregisterB := RegisterExporter("B", b)
+	unregisterB2 := RegisterExporter("B", b2)
+	defer unregisterA()
+	defer unregisterB()
+
+	end := func(service string) {
+		_, span := Start(context.Background(), service, "op", Server)
+		span.End(nil)
+	}
+	end("A")
+	end("B")
+	end("C") // 没有注册导出器, 交给默认导出器
+	unregisterB2()
+	unregisterB2() // 多次调用只有第一次有效
+	end("B")
+
+	tests := []struct {
+		name string
+		r    *recorder
+		want []string
+	}{
+		{"A的导出器", a, []string{"A"}},
+		{"B先注册的导出器", b, []string{"B"}},
+		{"B后注册的导出器", b2, []string{"B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.got(); !slices.Equal(got, tt.want) {
+				t.Fatalf("收到%v, 期望%v", got, tt.want)
+			}
+		})
+	}
+}